@@ -0,0 +1,112 @@
+package cart
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownProduct = errors.New("unknown product")
+	ErrOutOfStock     = errors.New("product out of stock")
+	// ErrVariantRequired is returned when a product sold in variants is
+	// added without the SKU of one of them.
+	ErrVariantRequired = errors.New("product variant required")
+	// ErrCatalogueUnavailable is returned when the catalogue cannot say
+	// whether a product exists.
+	ErrCatalogueUnavailable = errors.New("catalogue unavailable")
+)
+
+// Product is the part of a catalogue sock the cart relies on.
+type Product struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Price    float64   `json:"price"`
+	Count    int       `json:"count"`
+	Variants []Variant `json:"variants"`
+}
+
+// Variant is a version of a product, such as one size of it, with a price
+// and stock of its own.
+type Variant struct {
+	SKU   string  `json:"sku"`
+	Price float64 `json:"price"`
+	Count int     `json:"count"`
+}
+
+// Variant returns the price and stock of the variant with the SKU, or of
+// the product itself if the SKU is empty. A product sold in variants has no
+// stock of its own, so one of them must be chosen.
+func (p *Product) Variant(sku string) (Variant, error) {
+	if sku == "" {
+		if len(p.Variants) > 0 {
+			return Variant{}, ErrVariantRequired
+		}
+		return Variant{Price: p.Price, Count: p.Count}, nil
+	}
+	for _, v := range p.Variants {
+		if v.SKU == sku {
+			return v, nil
+		}
+	}
+	return Variant{}, fmt.Errorf("%w: sku %q", ErrUnknownProduct, sku)
+}
+
+// Catalogue looks up products so that carts are priced by the catalogue
+// rather than by the client.
+type Catalogue interface {
+	GetProduct(ctx context.Context, id string) (*Product, error)
+}
+
+type catalogueClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewCatalogueClient(baseURL string) Catalogue {
+	return &catalogueClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: time.Duration(5) * time.Second},
+	}
+}
+
+func (c *catalogueClient) GetProduct(ctx context.Context, id string) (*Product, error) {
+	u := fmt.Sprintf("%s/catalogue/%s", c.baseURL, url.PathEscape(id))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCatalogueUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrUnknownProduct
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: catalogue responded %s", ErrCatalogueUnavailable, res.Status)
+	}
+
+	body := struct {
+		Sock Product         `json:"sock"`
+		Err  json.RawMessage `json:"Err"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	// the catalogue reports its own failures with a 200 and an empty sock
+	if len(body.Err) > 0 && string(body.Err) != "null" {
+		return nil, fmt.Errorf("%w: catalogue failed to get %s", ErrCatalogueUnavailable, id)
+	}
+	if body.Sock.ID == "" {
+		return nil, ErrUnknownProduct
+	}
+	return &body.Sock, nil
+}
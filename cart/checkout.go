@@ -0,0 +1,43 @@
+package cart
+
+import (
+	"errors"
+	"time"
+)
+
+type SnapshotStatus string
+
+const (
+	SnapshotOpen     SnapshotStatus = "open"
+	SnapshotConsumed SnapshotStatus = "consumed"
+	SnapshotReleased SnapshotStatus = "released"
+	SnapshotExpired  SnapshotStatus = "expired"
+)
+
+var (
+	ErrCartLocked      = errors.New("cart is locked for checkout")
+	ErrEmptyCart       = errors.New("cart is empty")
+	ErrSnapshotExpired = errors.New("checkout snapshot expired")
+	ErrSnapshotClosed  = errors.New("checkout snapshot already closed")
+)
+
+// Snapshot freezes the lines and prices of a cart for checkout. The cart is
+// locked against changes until the snapshot is consumed by an order,
+// released or expires.
+type Snapshot struct {
+	ID         string         `json:"id" bson:"id"`
+	CustomerID string         `json:"customerID" bson:"customerID"`
+	CartID     string         `json:"cartID" bson:"cartID"`
+	Items      []Item         `json:"items" bson:"items"`
+	Summary    Summary        `json:"summary" bson:"summary"`
+	Status     SnapshotStatus `json:"status" bson:"status"`
+	CreatedAt  time.Time      `json:"createdAt" bson:"createdAt"`
+	ExpiresAt  time.Time      `json:"expiresAt" bson:"expiresAt"`
+	// CartUpdatedAt is when the cart last changed before the snapshot was
+	// taken, so that a cart changed meanwhile is not locked.
+	CartUpdatedAt time.Time `json:"-" bson:"cartUpdatedAt"`
+}
+
+func (s *Snapshot) expired(t time.Time) bool {
+	return s.Status == SnapshotOpen && !t.Before(s.ExpiresAt)
+}
@@ -1,5 +1,134 @@
 package main
 
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"cart"
+
+	"go.uber.org/zap"
+)
+
+var (
+	zip        string
+	port       string
+	database   string
+	catalogue  string
+	pricing    = cart.DefaultPricingPolicy
+	limits     = cart.DefaultLimits
+	cartTTL    time.Duration
+	purge      time.Duration
+	checkout   time.Duration
+	promotions string
+	eventsFile string
+	webhook    string
+	queue      int
+)
+
+const (
+	ServiceName = "cart"
+)
+
 func main() {
+	flag.StringVar(&zip, "zipkin", os.Getenv("ZIPKIN"), "Zipkin address")
+	flag.StringVar(&port, "port", "8081", "Port on which to run")
+	flag.StringVar(&database, "database", os.Getenv("CART_DATABASE"), "Database to use, mongodb or memory")
+	flag.StringVar(&catalogue, "catalogue", "http://catalogue", "Catalogue service base URL")
+	flag.Float64Var(&pricing.Shipping, "shipping", pricing.Shipping, "Flat shipping charge per cart")
+	flag.Float64Var(&pricing.FreeShippingOver, "free-shipping-over", pricing.FreeShippingOver, "Subtotal from which shipping is free, 0 to always charge")
+	flag.Float64Var(&pricing.TaxRate, "tax-rate", pricing.TaxRate, "Tax rate applied to the subtotal")
+	flag.DurationVar(&cartTTL, "anonymous-cart-ttl", 72*time.Hour, "Idle time after which anonymous carts are purged, 0 to keep them")
+	flag.DurationVar(&purge, "purge-interval", time.Hour, "Interval between purges of anonymous carts")
+	flag.DurationVar(&checkout, "checkout-ttl", 15*time.Minute, "Time a checkout snapshot locks the cart for")
+	flag.IntVar(&limits.MaxQuantity, "max-quantity", limits.MaxQuantity, "Maximum quantity of a cart line, 0 for no limit")
+	flag.IntVar(&limits.MaxLines, "max-lines", limits.MaxLines, "Maximum number of lines in a cart, 0 for no limit")
+	flag.StringVar(&promotions, "promotions", os.Getenv("CART_PROMOTIONS"), "JSON file of promotions on offer")
+	flag.StringVar(&eventsFile, "events-file", "", "File to append cart events to as JSON lines")
+	flag.StringVar(&webhook, "events-webhook", "", "URL to post cart events to")
+	flag.IntVar(&queue, "events-webhook-queue", 1000, "Number of cart events queued for the webhook before they are dropped")
+
+	flag.Parse()
+
+	logger := zap.L()
+
+	// TODO: tracer
+
+	if cartTTL > 0 && purge <= 0 {
+		logger.Fatal("purge interval must be positive while anonymous carts expire", zap.Duration("purge-interval", purge))
+	}
+
+	if promotions != "" {
+		p, err := cart.LoadPromotions(promotions)
+		if err != nil {
+			logger.Fatal("load promotions", zap.Error(err))
+		}
+		pricing.Promotions = p
+	}
+
+	var db cart.Database
+	switch database {
+	case "", "mongodb":
+		db = new(cart.Mongo)
+	case "memory":
+		db = cart.NewMemory()
+	default:
+		logger.Fatal("unknown database", zap.String("database", database))
+	}
+
+	dbconn := false
+	for !dbconn {
+		err := db.Init()
+		if err != nil {
+			logger.Error("", zap.Error(err))
+			time.Sleep(time.Second)
+		} else {
+			dbconn = true
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if cartTTL > 0 {
+		go cart.RunJanitor(ctx, db, cartTTL, purge, logger)
+	}
+
+	service := cart.NewService(db, cart.NewCatalogueClient(catalogue), pricing, limits, checkout, logger)
+	publishers := cart.Publishers{}
+	if eventsFile != "" {
+		p, err := cart.NewFilePublisher(eventsFile)
+		if err != nil {
+			logger.Fatal("open events file", zap.Error(err))
+		}
+		defer p.Close()
+		publishers = append(publishers, p)
+	}
+	if webhook != "" {
+		p := cart.NewWebhookPublisher(webhook, queue, logger)
+		defer p.Close()
+		publishers = append(publishers, p)
+	}
+	if len(publishers) > 0 {
+		service = cart.EventMiddleware(publishers, logger)(service)
+	}
+	service = cart.LoggingMiddleware(logger)(service)
+	router := cart.MakeHTTPHandler(service)
+
+	errc := make(chan error)
+	go func() {
+		logger.Info("transport HTTP", zap.String("port", port))
+		errc <- router.Listen(":" + port)
+	}()
+
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+		errc <- fmt.Errorf("%s", <-c)
+	}()
 
+	logger.Info("exit", zap.Error(<-errc))
 }
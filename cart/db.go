@@ -2,29 +2,41 @@ package cart
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"net/url"
 	"os"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
-	"gopkg.in/mgo.v2/bson"
 )
 
 var (
-	name     string
-	password string
-	host     string
+	name        string
+	password    string
+	host        string
+	ErrNotFound = errors.New("not found")
+	// ErrVersionConflict is returned when an item was changed since the
+	// version the caller based its update on.
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrRegisteredSession is returned when the session a cart is merged
+	// from is that of a registered customer, whose cart is not anyone's to
+	// take.
+	ErrRegisteredSession = errors.New("session belongs to a registered customer")
 )
 
 const (
-	databaseName            = "cart"
-	customersCollectionName = "customers"
-	cartsCollectionName     = "carts"
-	itemsCollectionName     = "items"
+	databaseName              = "cart"
+	customersCollectionName   = "customers"
+	cartsCollectionName       = "carts"
+	itemsCollectionName       = "items"
+	listsCollectionName       = "lists"
+	redemptionsCollectionName = "redemptions"
+	snapshotsCollectionName   = "snapshots"
 )
 
 func init() {
@@ -46,33 +58,131 @@ func getURL() url.URL {
 	return ur
 }
 
-var db *Mongo
+type Database interface {
+	Init() error
+
+	GetCart(ctx context.Context, customerID string) (*Cart, error)
+	DeleteCart(ctx context.Context, customerID string) error
+	MargeCart(ctx context.Context, customerID string, sessionID string) error
+	SetCoupon(ctx context.Context, customerID string, code string) error
+
+	GetItem(ctx context.Context, customerID string, itemID string) (*Item, error)
+	GetItems(ctx context.Context, customerID string) (*[]Item, error)
+	CreateItem(ctx context.Context, customerID string, item *Item) error
+	CreateItems(ctx context.Context, customerID string, items []Item) error
+	ReplaceItems(ctx context.Context, customerID string, items []Item) error
+	DeleteItem(ctx context.Context, customerID string, itemID string) error
+	UpdateItem(ctx context.Context, customerID string, item *Item) error
+
+	GetList(ctx context.Context, customerID string, name string) (*List, error)
+	AddListItem(ctx context.Context, customerID string, name string, item *Item) error
+	DeleteListItem(ctx context.Context, customerID string, name string, itemID string) error
+	MoveToList(ctx context.Context, customerID string, name string, itemID string) error
+	MoveToCart(ctx context.Context, customerID string, name string, item *Item) error
+
+	LockedUntil(ctx context.Context, customerID string) (time.Time, error)
+	CreateSnapshot(ctx context.Context, snapshot *Snapshot) error
+	GetSnapshot(ctx context.Context, id string) (*Snapshot, error)
+	CloseSnapshot(ctx context.Context, id string, status SnapshotStatus) error
+	// ConsumeSnapshot closes the snapshot as consumed, redeems its coupon
+	// within limit and deletes the cart, doing none of it unless the
+	// snapshot is still open and the coupon can be redeemed.
+	ConsumeSnapshot(ctx context.Context, id string, limit int) error
+
+	Redemptions(ctx context.Context, code string, customerID string) (int, error)
+	// Redeem counts a redemption of the coupon by the customer, failing with
+	// ErrPromotionLimitReached if they already redeemed it limit times. A
+	// zero limit leaves redemptions unbounded.
+	Redeem(ctx context.Context, code string, customerID string, limit int) error
+
+	PurgeCarts(ctx context.Context, before time.Time) (int, error)
+	Ping(ctx context.Context) error
+}
 
 type Mongo struct {
 	Client *mongo.Client
 }
 
-func InitDB() error {
+func (m *Mongo) Init() error {
 	u := getURL()
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
 	defer cancel()
-	db = new(Mongo)
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(u.Path))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(u.String()))
 	if err != nil {
 		return err
 	}
-	db.Client = client
-	return nil
+	m.Client = client
+	return m.EnsureIndexes(ctx)
+}
+
+// EnsureIndexes ensures a product variant appears at most once per cart,
+// that abandoned carts can be found by age, that a customer has one list of
+// each name and one redemption count per coupon. Items stored before they
+// referenced a product have neither a cartID nor an itemID, so they are
+// left out of the unique index rather than colliding on nulls.
+func (m *Mongo) EnsureIndexes(ctx context.Context) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5)*time.Second)
+	defer cancel()
+
+	return m.Client.UseSession(_ctx, func(s mongo.SessionContext) error {
+		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
+		// the index of a product per cart predates variants, and would
+		// refuse two sizes of the same sock
+		if _, err := itemsCol.Indexes().DropOne(s, "cartID_1_itemID_1"); err != nil && !isIndexNotFound(err) {
+			return err
+		}
+		index := mongo.IndexModel{Keys: bson.D{{Key: "cartID", Value: 1}, {Key: "itemID", Value: 1}, {Key: "sku", Value: 1}}}
+		index.Options = options.Index()
+		index.Options.SetUnique(true).SetBackground(true).SetPartialFilterExpression(bson.M{
+			"cartID": bson.M{"$exists": true},
+			"itemID": bson.M{"$exists": true},
+		})
+		if _, err := itemsCol.Indexes().CreateOne(s, index); err != nil {
+			return err
+		}
+
+		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+		index = mongo.IndexModel{Keys: bson.D{{Key: "anonymous", Value: 1}, {Key: "updatedAt", Value: 1}}}
+		index.Options = options.Index()
+		index.Options.SetBackground(true)
+		if _, err := cartsCol.Indexes().CreateOne(s, index); err != nil {
+			return err
+		}
+
+		listsCol := s.Client().Database(databaseName).Collection(listsCollectionName)
+		index = mongo.IndexModel{Keys: bson.D{{Key: "customer", Value: 1}, {Key: "name", Value: 1}}}
+		index.Options = options.Index()
+		index.Options.SetUnique(true).SetBackground(true).SetSparse(false)
+		if _, err := listsCol.Indexes().CreateOne(s, index); err != nil {
+			return err
+		}
+
+		redemptionsCol := s.Client().Database(databaseName).Collection(redemptionsCollectionName)
+		index = mongo.IndexModel{Keys: bson.D{{Key: "code", Value: 1}, {Key: "customer", Value: 1}}}
+		index.Options = options.Index()
+		index.Options.SetUnique(true).SetBackground(true)
+		_, err := redemptionsCol.Indexes().CreateOne(s, index)
+		return err
+	})
 }
 
 type MongoCustomer struct {
 	ID     primitive.ObjectID `bson:"_id"`
 	CartID primitive.ObjectID `bson:"cart"`
+	// Registered is set once a session cart is merged into the customer's
+	// on login, after which their carts are never anonymous.
+	Registered bool `bson:"registered"`
 }
 
 type MongoCart struct {
-	ID      primitive.ObjectID   `bson:"_id"`
-	ItemIDs []primitive.ObjectID `bson:"items"`
+	ID          primitive.ObjectID   `bson:"_id"`
+	ItemIDs     []primitive.ObjectID `bson:"items"`
+	Coupon      string               `bson:"coupon"`
+	Checkout    string               `bson:"checkout"`
+	LockedUntil time.Time            `bson:"lockedUntil"`
+	Anonymous   bool                 `bson:"anonymous"`
+	CreatedAt   time.Time            `bson:"createdAt"`
+	UpdatedAt   time.Time            `bson:"updatedAt"`
 }
 
 type MongoItem struct {
@@ -80,6 +190,14 @@ type MongoItem struct {
 	Value Item               `bson:",inline"`
 }
 
+type MongoList struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	CustomerID primitive.ObjectID `bson:"customer"`
+	Name       string             `bson:"name"`
+	Items      []Item             `bson:"items"`
+	UpdatedAt  time.Time          `bson:"updatedAt"`
+}
+
 func isID(id primitive.ObjectID) bson.M {
 	return bson.M{"_id": id}
 }
@@ -88,27 +206,109 @@ func inID(ids []primitive.ObjectID) bson.M {
 	return bson.M{"_id": bson.M{"$in": ids}}
 }
 
+func (m *Mongo) withTransaction(ctx context.Context, fn func(s mongo.SessionContext) error) error {
+	return m.Client.UseSession(ctx, func(s mongo.SessionContext) error {
+		_, err := s.WithTransaction(s, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, fn(sc)
+		})
+		return err
+	})
+}
+
+// customerCart finds the cart of the customer, creating the customer and an
+// empty cart on first use. A registered customer whose cart was deleted is
+// given a new one, owned like the last.
+func customerCart(s mongo.SessionContext, customerID string) (*MongoCustomer, *MongoCart, error) {
+	customerObjectID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	customersCol := s.Client().Database(databaseName).Collection(customersCollectionName)
+	cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+
+	mongoCustomer := new(MongoCustomer)
+	err = customersCol.FindOne(s, isID(customerObjectID)).Decode(mongoCustomer)
+	if err == mongo.ErrNoDocuments {
+		mongoCart, err := newCart(s, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		mongoCustomer = &MongoCustomer{
+			ID:     customerObjectID,
+			CartID: mongoCart.ID,
+		}
+		if _, err := customersCol.InsertOne(s, mongoCustomer); err != nil {
+			return nil, nil, err
+		}
+		return mongoCustomer, mongoCart, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mongoCart := new(MongoCart)
+	err = cartsCol.FindOne(s, isID(mongoCustomer.CartID)).Decode(mongoCart)
+	if err == mongo.ErrNoDocuments {
+		mongoCart, err = newCart(s, mongoCustomer.Registered)
+		if err != nil {
+			return nil, nil, err
+		}
+		mongoCustomer.CartID = mongoCart.ID
+		if _, err := customersCol.UpdateOne(s, isID(mongoCustomer.ID), bson.M{"$set": bson.M{"cart": mongoCart.ID}}); err != nil {
+			return nil, nil, err
+		}
+		return mongoCustomer, mongoCart, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return mongoCustomer, mongoCart, nil
+}
+
+// unlockedCart is customerCart for a change to the cart, which fails with
+// ErrCartLocked while an open checkout snapshot locks the cart. Every change
+// writes the cart, so one racing a snapshot conflicts with it and is retried
+// against the locked cart.
+func unlockedCart(s mongo.SessionContext, customerID string) (*MongoCustomer, *MongoCart, error) {
+	mongoCustomer, mongoCart, err := customerCart(s, customerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mongoCart.LockedUntil.After(time.Now()) {
+		return nil, nil, ErrCartLocked
+	}
+	return mongoCustomer, mongoCart, nil
+}
+
+// newCart stores an empty cart, anonymous unless it belongs to a registered
+// customer.
+func newCart(s mongo.SessionContext, registered bool) (*MongoCart, error) {
+	now := time.Now()
+	mongoCart := &MongoCart{
+		ID:        primitive.NewObjectID(),
+		ItemIDs:   []primitive.ObjectID{},
+		Anonymous: !registered,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+	if _, err := cartsCol.InsertOne(s, mongoCart); err != nil {
+		return nil, err
+	}
+	return mongoCart, nil
+}
+
 func (m *Mongo) GetCart(ctx context.Context, customerID string) (*Cart, error) {
 	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
 	defer cancel()
 
 	cart := new(Cart)
-	err := m.Client.UseSession(_ctx, func(s mongo.SessionContext) error {
-		customerObjectID, err := primitive.ObjectIDFromHex(customerID)
+	err := m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		mongoCustomer, mongoCart, err := customerCart(s, customerID)
 		if err != nil {
 			return err
 		}
-		customersCol := s.Client().Database(databaseName).Collection(customersCollectionName)
-		mongoCustomer := new(MongoCustomer)
-		if err := customersCol.FindOne(s, isID(customerObjectID)).Decode(mongoCustomer); err != nil {
-			return err
-		}
-
-		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
-		mongoCart := new(MongoCart)
-		if err := cartsCol.FindOne(s, isID(mongoCustomer.CartID)).Decode(mongoCart); err != nil {
-			return err
-		}
 
 		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
 		mongoItems := new([]MongoItem)
@@ -120,15 +320,20 @@ func (m *Mongo) GetCart(ctx context.Context, customerID string) (*Cart, error) {
 			return err
 		}
 
-		items := make([]Item, len(*mongoItems))
+		items := make([]Item, 0, len(*mongoItems))
 		for _, mongoItem := range *mongoItems {
 			items = append(items, mongoItem.Value)
 		}
 
 		cart = &Cart{
-			ID:         mongoCart.ID.Hex(),
-			CustomerID: mongoCustomer.ID.Hex(),
-			Items:      items,
+			ID:          mongoCart.ID.Hex(),
+			CustomerID:  mongoCustomer.ID.Hex(),
+			Items:       items,
+			Coupon:      mongoCart.Coupon,
+			Checkout:    mongoCart.Checkout,
+			LockedUntil: mongoCart.LockedUntil,
+			CreatedAt:   mongoCart.CreatedAt,
+			UpdatedAt:   mongoCart.UpdatedAt,
 		}
 		return nil
 	})
@@ -139,88 +344,217 @@ func (m *Mongo) DeleteCart(ctx context.Context, customerID string) error {
 	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
 	defer cancel()
 
-	return m.Client.UseSession(_ctx, func(s mongo.SessionContext) error {
-		customerObjectID, err := primitive.ObjectIDFromHex(customerID)
-		if err != nil {
-			return err
-		}
-		customersCol := s.Client().Database(databaseName).Collection(customersCollectionName)
-		mongoCustomer := new(MongoCustomer)
-		if err := customersCol.FindOne(s, isID(customerObjectID)).Decode(mongoCustomer); err != nil {
-			return err
-		}
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		return removeCart(s, customerID)
+	})
+}
 
-		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
-		mongoCart := new(MongoCart)
-		if err := cartsCol.FindOne(s, isID(mongoCustomer.CartID)).Decode(mongoCart); err != nil {
-			return err
+// removeCart deletes the cart of the customer and its items, failing with
+// ErrCartLocked while a checkout snapshot locks it.
+func removeCart(s mongo.SessionContext, customerID string) error {
+	customerObjectID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return err
+	}
+	customersCol := s.Client().Database(databaseName).Collection(customersCollectionName)
+	mongoCustomer := new(MongoCustomer)
+	if err := customersCol.FindOne(s, isID(customerObjectID)).Decode(mongoCustomer); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrNotFound
 		}
+		return err
+	}
 
-		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
-		if _, err = itemsCol.DeleteMany(s, inID(mongoCart.ItemIDs)); err != nil {
-			return err
+	cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+	mongoCart := new(MongoCart)
+	if err := cartsCol.FindOne(s, isID(mongoCustomer.CartID)).Decode(mongoCart); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrNotFound
 		}
+		return err
+	}
+	if mongoCart.LockedUntil.After(time.Now()) {
+		return ErrCartLocked
+	}
 
-		_, err = cartsCol.DeleteOne(s, isID(mongoCart.ID))
+	itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
+	if _, err = itemsCol.DeleteMany(s, inID(mongoCart.ItemIDs)); err != nil {
 		return err
-	})
+	}
+
+	if _, err = cartsCol.DeleteOne(s, isID(mongoCart.ID)); err != nil {
+		return err
+	}
+	// a registered customer is kept, so that their next cart is theirs
+	// rather than anonymous
+	if mongoCustomer.Registered {
+		return nil
+	}
+	_, err = customersCol.DeleteOne(s, isID(mongoCustomer.ID))
+	return err
 }
 
 func (m *Mongo) MargeCart(ctx context.Context, customerID string, sessionID string) error {
 	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
 	defer cancel()
 
-	return m.Client.UseSession(_ctx, func(s mongo.SessionContext) error {
-		customerObjectID, err := primitive.ObjectIDFromHex(customerID)
+	if customerID == sessionID {
+		return nil
+	}
+
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		sessionCustomerObjectID, err := primitive.ObjectIDFromHex(sessionID)
 		if err != nil {
 			return err
 		}
-		customersCol := s.Client().Database(databaseName).Collection(customersCollectionName)
-		mongoCustomer := new(MongoCustomer)
-		if err := customersCol.FindOne(s, isID(customerObjectID)).Decode(mongoCustomer); err != nil {
-			return err
-		}
 
+		customersCol := s.Client().Database(databaseName).Collection(customersCollectionName)
 		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
-		mongoCart := new(MongoCart)
-		if err := cartsCol.FindOne(s, isID(mongoCustomer.CartID)).Decode(mongoCart); err != nil {
+		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
+
+		// the session cart is gone once merged, so a repeated merge is a no-op
+		sessionMongoCustomer := new(MongoCustomer)
+		err = customersCol.FindOne(s, isID(sessionCustomerObjectID)).Decode(sessionMongoCustomer)
+		if err != nil && err != mongo.ErrNoDocuments {
 			return err
 		}
+		merged := err == mongo.ErrNoDocuments
+		if !merged && sessionMongoCustomer.Registered {
+			return ErrRegisteredSession
+		}
 
-		sessionCustomerObjectID, err := primitive.ObjectIDFromHex(sessionID)
+		// a customer logging in owns their cart, and every cart they have
+		// after it, so it no longer expires
+		mongoCustomer, mongoCart, err := unlockedCart(s, customerID)
 		if err != nil {
 			return err
 		}
-
-		sessionMongoCustomer := new(MongoCustomer)
-		if err := customersCol.FindOne(s, isID(sessionCustomerObjectID)).Decode(sessionMongoCustomer); err != nil {
+		if _, err := customersCol.UpdateOne(s, isID(mongoCustomer.ID), bson.M{"$set": bson.M{"registered": true}}); err != nil {
+			return err
+		}
+		if _, err := cartsCol.UpdateOne(s, isID(mongoCart.ID), bson.M{"$set": bson.M{"anonymous": false, "updatedAt": time.Now()}}); err != nil {
+			return err
+		}
+		if err := mergeLists(s, customerID, sessionCustomerObjectID); err != nil {
 			return err
 		}
 
+		if merged {
+			return nil
+		}
+
 		sessionMongoCart := new(MongoCart)
 		if err := cartsCol.FindOne(s, isID(sessionMongoCustomer.CartID)).Decode(sessionMongoCart); err != nil {
 			return err
 		}
+		if sessionMongoCart.LockedUntil.After(time.Now()) {
+			return ErrCartLocked
+		}
+
+		cursor, err := itemsCol.Find(s, inID(mongoCart.ItemIDs))
+		if err != nil {
+			return err
+		}
+		mongoItems := new([]MongoItem)
+		if err := cursor.All(s, mongoItems); err != nil {
+			return err
+		}
+		existing := map[line]primitive.ObjectID{}
+		for _, mongoItem := range *mongoItems {
+			existing[mongoItem.Value.line()] = mongoItem.ID
+		}
+
+		cursor, err = itemsCol.Find(s, inID(sessionMongoCart.ItemIDs))
+		if err != nil {
+			return err
+		}
+		sessionMongoItems := new([]MongoItem)
+		if err := cursor.All(s, sessionMongoItems); err != nil {
+			return err
+		}
 
-		mongoCart.ItemIDs = append(mongoCart.ItemIDs, sessionMongoCart.ItemIDs...)
-		_, err = cartsCol.UpdateOne(s, isID(mongoCart.ID), bson.M{"items": mongoCart.ItemIDs})
+		for _, sessionMongoItem := range *sessionMongoItems {
+			if id, ok := existing[sessionMongoItem.Value.line()]; ok {
+				if _, err := itemsCol.UpdateOne(s, isID(id), bson.M{"$inc": bson.M{"quantity": sessionMongoItem.Value.Quantity, "version": 1}}); err != nil {
+					return err
+				}
+				if _, err := itemsCol.DeleteOne(s, isID(sessionMongoItem.ID)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if _, err := itemsCol.UpdateOne(s, isID(sessionMongoItem.ID), bson.M{"$set": bson.M{"cartID": mongoCart.ID.Hex()}}); err != nil {
+				return err
+			}
+			if _, err := cartsCol.UpdateOne(s, isID(mongoCart.ID), bson.M{"$addToSet": bson.M{"items": sessionMongoItem.ID}}); err != nil {
+				return err
+			}
+			existing[sessionMongoItem.Value.line()] = sessionMongoItem.ID
+		}
+
+		if _, err := cartsCol.DeleteOne(s, isID(sessionMongoCart.ID)); err != nil {
+			return err
+		}
+		_, err = customersCol.DeleteOne(s, isID(sessionMongoCustomer.ID))
 		return err
 	})
 }
 
+// mergeLists adds the items of the session's lists to the customer's lists
+// of the same name and deletes the session's lists.
+func mergeLists(s mongo.SessionContext, customerID string, sessionObjectID primitive.ObjectID) error {
+	listsCol := s.Client().Database(databaseName).Collection(listsCollectionName)
+	cursor, err := listsCol.Find(s, bson.M{"customer": sessionObjectID})
+	if err != nil {
+		return err
+	}
+	sessionLists := new([]MongoList)
+	if err := cursor.All(s, sessionLists); err != nil {
+		return err
+	}
+	if len(*sessionLists) == 0 {
+		return nil
+	}
+
+	for _, sessionList := range *sessionLists {
+		mongoList, err := customerList(s, customerID, sessionList.Name)
+		if err != nil {
+			return err
+		}
+		for i := range sessionList.Items {
+			mongoList.Items = mergeListItem(mongoList.Items, &sessionList.Items[i])
+		}
+		if err := saveList(s, mongoList); err != nil {
+			return err
+		}
+	}
+	_, err = listsCol.DeleteMany(s, bson.M{"customer": sessionObjectID})
+	return err
+}
+
 func (m *Mongo) GetItem(ctx context.Context, customerID string, itemID string) (*Item, error) {
 	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
 	defer cancel()
 
 	item := new(Item)
-	err := m.Client.UseSession(_ctx, func(s mongo.SessionContext) error {
+	err := m.withTransaction(_ctx, func(s mongo.SessionContext) error {
 		itemObjectID, err := primitive.ObjectIDFromHex(itemID)
 		if err != nil {
 			return err
 		}
+
+		_, mongoCart, err := customerCart(s, customerID)
+		if err != nil {
+			return err
+		}
+
 		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
 		mongoItem := new(MongoItem)
-		if err := itemsCol.FindOne(s, isID(itemObjectID)).Decode(mongoItem); err != nil {
+		if err := itemsCol.FindOne(s, bson.M{"_id": itemObjectID, "cartID": mongoCart.ID.Hex()}).Decode(mongoItem); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrNotFound
+			}
 			return err
 		}
 
@@ -235,25 +569,13 @@ func (m *Mongo) GetItems(ctx context.Context, customerID string) (*[]Item, error
 	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
 	defer cancel()
 
-	items := new([]Item)
-	err := m.Client.UseSession(_ctx, func(s mongo.SessionContext) error {
-		customerObjectID, err := primitive.ObjectIDFromHex(customerID)
+	items := &[]Item{}
+	err := m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		_, mongoCart, err := customerCart(s, customerID)
 		if err != nil {
 			return err
 		}
 
-		customerCol := s.Client().Database(databaseName).Collection(customersCollectionName)
-		mongoCustomer := new(MongoCustomer)
-		if err := customerCol.FindOne(s, isID(customerObjectID)).Decode(mongoCustomer); err != nil {
-			return err
-		}
-
-		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
-		mongoCart := new(MongoCart)
-		if err := cartsCol.FindOne(s, isID(mongoCustomer.CartID)).Decode(mongoCart); err != nil {
-			return err
-		}
-
 		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
 		cursor, err := itemsCol.Find(s, inID(mongoCart.ItemIDs))
 		if err != nil {
@@ -278,44 +600,135 @@ func (m *Mongo) CreateItem(ctx context.Context, customerID string, item *Item) e
 	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
 	defer cancel()
 
-	return m.Client.UseSession(_ctx, func(s mongo.SessionContext) error {
-		customerObjectID, err := primitive.ObjectIDFromHex(customerID)
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		_, mongoCart, err := unlockedCart(s, customerID)
+		if err != nil {
+			return err
+		}
+		return addItem(s, mongoCart, item)
+	})
+}
+
+func (m *Mongo) CreateItems(ctx context.Context, customerID string, items []Item) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		_, mongoCart, err := unlockedCart(s, customerID)
 		if err != nil {
 			return err
 		}
+		for i := range items {
+			if err := addItem(s, mongoCart, &items[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m *Mongo) ReplaceItems(ctx context.Context, customerID string, items []Item) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
 
-		mongoCustomer := new(MongoCustomer)
-		if err := s.Client().Database(databaseName).Collection(customersCollectionName).FindOne(s, isID(customerObjectID)).Decode(mongoCustomer); err != nil {
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		_, mongoCart, err := unlockedCart(s, customerID)
+		if err != nil {
 			return err
 		}
-		mongoCart := new(MongoCart)
-		if err := s.Client().Database(databaseName).Collection(cartsCollectionName).FindOne(s, isID(mongoCustomer.CartID)).Decode(mongoCart); err != nil {
+
+		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
+		if _, err := itemsCol.DeleteMany(s, inID(mongoCart.ItemIDs)); err != nil {
 			return err
 		}
 
-		itemObjectID := primitive.NewObjectID()
-		item.ID = itemObjectID.Hex()
-		mongoItem := MongoItem{
-			ID:    itemObjectID,
-			Value: *item,
+		itemIDs := make([]primitive.ObjectID, 0, len(items))
+		for i := range items {
+			itemObjectID := primitive.NewObjectID()
+			items[i].ID = itemObjectID.Hex()
+			items[i].CartID = mongoCart.ID.Hex()
+			items[i].Version = 1
+			mongoItem := MongoItem{
+				ID:    itemObjectID,
+				Value: items[i],
+			}
+			if _, err := itemsCol.InsertOne(s, mongoItem); err != nil {
+				return err
+			}
+			itemIDs = append(itemIDs, itemObjectID)
 		}
-		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
-		_, err = itemsCol.InsertOne(s, mongoItem)
+
+		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+		update := bson.M{"$set": bson.M{"items": itemIDs, "updatedAt": time.Now()}}
+		_, err = cartsCol.UpdateOne(s, isID(mongoCart.ID), update)
 		return err
 	})
 }
 
+// addItem adds the item to the cart, adding its quantity to the line of the
+// same product and variant if the cart already has one.
+func addItem(s mongo.SessionContext, mongoCart *MongoCart, item *Item) error {
+	itemObjectID := primitive.NewObjectID()
+	filter := bson.M{"cartID": mongoCart.ID.Hex(), "itemID": item.ItemID, "sku": item.SKU}
+	if item.SKU == "" {
+		// lines stored before products had variants have no sku at all
+		filter["sku"] = bson.M{"$in": bson.A{"", nil}}
+	}
+	update := bson.M{
+		"$inc":         bson.M{"quantity": item.Quantity, "version": 1},
+		"$set":         bson.M{"unitPrice": item.UnitPrice},
+		"$setOnInsert": bson.M{"_id": itemObjectID, "id": itemObjectID.Hex(), "sku": item.SKU},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
+	mongoItem := new(MongoItem)
+	if err := itemsCol.FindOneAndUpdate(s, filter, update, opts).Decode(mongoItem); err != nil {
+		return err
+	}
+
+	cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+	update = bson.M{
+		"$addToSet": bson.M{"items": mongoItem.ID},
+		"$set":      bson.M{"updatedAt": time.Now()},
+	}
+	if _, err := cartsCol.UpdateOne(s, isID(mongoCart.ID), update); err != nil {
+		return err
+	}
+
+	*item = mongoItem.Value
+	return nil
+}
+
 func (m *Mongo) DeleteItem(ctx context.Context, customerID string, itemID string) error {
 	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
 	defer cancel()
 
-	return m.Client.UseSession(_ctx, func(s mongo.SessionContext) error {
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
 		itemObjectID, err := primitive.ObjectIDFromHex(itemID)
 		if err != nil {
 			return err
 		}
 
-		_, err = s.Client().Database(databaseName).Collection(itemsCollectionName).DeleteOne(s, isID(itemObjectID))
+		_, mongoCart, err := unlockedCart(s, customerID)
+		if err != nil {
+			return err
+		}
+
+		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
+		result, err := itemsCol.DeleteOne(s, bson.M{"_id": itemObjectID, "cartID": mongoCart.ID.Hex()})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return ErrNotFound
+		}
+
+		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+		update := bson.M{
+			"$pull": bson.M{"items": itemObjectID},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		}
+		_, err = cartsCol.UpdateOne(s, isID(mongoCart.ID), update)
 		return err
 	})
 }
@@ -324,23 +737,112 @@ func (m *Mongo) UpdateItem(ctx context.Context, customerID string, item *Item) e
 	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
 	defer cancel()
 
-	return m.Client.UseSession(_ctx, func(s mongo.SessionContext) error {
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
 		itemObjectID, err := primitive.ObjectIDFromHex(item.ID)
 		if err != nil {
 			return err
 		}
 
-		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
+		_, mongoCart, err := unlockedCart(s, customerID)
+		if err != nil {
+			return err
+		}
 
+		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
+		filter := bson.M{"_id": itemObjectID, "cartID": mongoCart.ID.Hex()}
+		if item.Version != 0 {
+			filter["version"] = item.Version
+		}
+		update := bson.M{
+			"$set": bson.M{"quantity": item.Quantity, "unitPrice": item.UnitPrice},
+			"$inc": bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 		mongoItem := new(MongoItem)
-		if err := itemsCol.FindOne(s, isID(itemObjectID)).Decode(mongoItem); err != nil {
+		if err := itemsCol.FindOneAndUpdate(s, filter, update, opts).Decode(mongoItem); err != nil {
+			if err != mongo.ErrNoDocuments {
+				return err
+			}
+			n, err := itemsCol.CountDocuments(s, bson.M{"_id": itemObjectID, "cartID": mongoCart.ID.Hex()})
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				return ErrVersionConflict
+			}
+			return ErrNotFound
+		}
+
+		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+		if _, err := cartsCol.UpdateOne(s, isID(mongoCart.ID), bson.M{"$set": bson.M{"updatedAt": time.Now()}}); err != nil {
+			return err
+		}
+
+		*item = mongoItem.Value
+		return nil
+	})
+}
+
+// PurgeCarts deletes anonymous carts, along with their items, lists and
+// customer mapping, that have not been updated since before.
+func (m *Mongo) PurgeCarts(ctx context.Context, before time.Time) (int, error) {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(30*time.Second))
+	defer cancel()
+
+	purged := 0
+	err := m.Client.UseSession(_ctx, func(s mongo.SessionContext) error {
+		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+		cursor, err := cartsCol.Find(s, bson.M{"anonymous": true, "updatedAt": bson.M{"$lt": before}})
+		if err != nil {
+			return err
+		}
+		mongoCarts := new([]MongoCart)
+		if err := cursor.All(s, mongoCarts); err != nil {
+			return err
+		}
+		if len(*mongoCarts) == 0 {
 			return nil
 		}
 
-		mongoItem.Value = *item
-		_, err = itemsCol.UpdateOne(s, isID(itemObjectID), mongoItem)
-		return err
+		cartIDs := make([]primitive.ObjectID, 0, len(*mongoCarts))
+		itemIDs := []primitive.ObjectID{}
+		for _, mongoCart := range *mongoCarts {
+			cartIDs = append(cartIDs, mongoCart.ID)
+			itemIDs = append(itemIDs, mongoCart.ItemIDs...)
+		}
+
+		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
+		if _, err := itemsCol.DeleteMany(s, inID(itemIDs)); err != nil {
+			return err
+		}
+		customersCol := s.Client().Database(databaseName).Collection(customersCollectionName)
+		cursor, err = customersCol.Find(s, bson.M{"cart": bson.M{"$in": cartIDs}})
+		if err != nil {
+			return err
+		}
+		mongoCustomers := new([]MongoCustomer)
+		if err := cursor.All(s, mongoCustomers); err != nil {
+			return err
+		}
+		customerIDs := make([]primitive.ObjectID, 0, len(*mongoCustomers))
+		for _, mongoCustomer := range *mongoCustomers {
+			customerIDs = append(customerIDs, mongoCustomer.ID)
+		}
+		listsCol := s.Client().Database(databaseName).Collection(listsCollectionName)
+		if _, err := listsCol.DeleteMany(s, bson.M{"customer": bson.M{"$in": customerIDs}}); err != nil {
+			return err
+		}
+		if _, err := customersCol.DeleteMany(s, inID(customerIDs)); err != nil {
+			return err
+		}
+		result, err := cartsCol.DeleteMany(s, inID(cartIDs))
+		if err != nil {
+			return err
+		}
+		purged = int(result.DeletedCount)
+		return nil
 	})
+	return purged, err
 }
 
 func (m *Mongo) Ping(ctx context.Context) error {
@@ -348,3 +850,401 @@ func (m *Mongo) Ping(ctx context.Context) error {
 	defer cancel()
 	return m.Client.Ping(_ctx, readpref.Primary())
 }
+
+// customerList finds the named list of the customer. A list that was never
+// written is returned empty without being stored.
+func customerList(s mongo.SessionContext, customerID string, name string) (*MongoList, error) {
+	customerObjectID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	listsCol := s.Client().Database(databaseName).Collection(listsCollectionName)
+	mongoList := new(MongoList)
+	err = listsCol.FindOne(s, bson.M{"customer": customerObjectID, "name": name}).Decode(mongoList)
+	if err == mongo.ErrNoDocuments {
+		return &MongoList{
+			ID:         primitive.NewObjectID(),
+			CustomerID: customerObjectID,
+			Name:       name,
+			Items:      []Item{},
+		}, nil
+	}
+	return mongoList, err
+}
+
+func saveList(s mongo.SessionContext, mongoList *MongoList) error {
+	mongoList.UpdatedAt = time.Now()
+	listsCol := s.Client().Database(databaseName).Collection(listsCollectionName)
+	filter := bson.M{"customer": mongoList.CustomerID, "name": mongoList.Name}
+	_, err := listsCol.ReplaceOne(s, filter, mongoList, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *Mongo) GetList(ctx context.Context, customerID string, name string) (*List, error) {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	list := new(List)
+	err := m.Client.UseSession(_ctx, func(s mongo.SessionContext) error {
+		mongoList, err := customerList(s, customerID, name)
+		if err != nil {
+			return err
+		}
+		list = &List{
+			Name:       mongoList.Name,
+			CustomerID: customerID,
+			Items:      mongoList.Items,
+			UpdatedAt:  mongoList.UpdatedAt,
+		}
+		return nil
+	})
+	return list, err
+}
+
+func (m *Mongo) AddListItem(ctx context.Context, customerID string, name string, item *Item) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		mongoList, err := customerList(s, customerID, name)
+		if err != nil {
+			return err
+		}
+		mongoList.Items = mergeListItem(mongoList.Items, item)
+		return saveList(s, mongoList)
+	})
+}
+
+func (m *Mongo) DeleteListItem(ctx context.Context, customerID string, name string, itemID string) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		mongoList, err := customerList(s, customerID, name)
+		if err != nil {
+			return err
+		}
+		items, _, err := takeListItem(mongoList.Items, itemID)
+		if err != nil {
+			return err
+		}
+		mongoList.Items = items
+		return saveList(s, mongoList)
+	})
+}
+
+func (m *Mongo) MoveToList(ctx context.Context, customerID string, name string, itemID string) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		itemObjectID, err := primitive.ObjectIDFromHex(itemID)
+		if err != nil {
+			return err
+		}
+
+		_, mongoCart, err := unlockedCart(s, customerID)
+		if err != nil {
+			return err
+		}
+
+		itemsCol := s.Client().Database(databaseName).Collection(itemsCollectionName)
+		mongoItem := new(MongoItem)
+		filter := bson.M{"_id": itemObjectID, "cartID": mongoCart.ID.Hex()}
+		if err := itemsCol.FindOneAndDelete(s, filter).Decode(mongoItem); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+		update := bson.M{
+			"$pull": bson.M{"items": itemObjectID},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		}
+		if _, err := cartsCol.UpdateOne(s, isID(mongoCart.ID), update); err != nil {
+			return err
+		}
+
+		mongoList, err := customerList(s, customerID, name)
+		if err != nil {
+			return err
+		}
+		mongoList.Items = mergeListItem(mongoList.Items, &mongoItem.Value)
+		return saveList(s, mongoList)
+	})
+}
+
+func (m *Mongo) MoveToCart(ctx context.Context, customerID string, name string, item *Item) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		mongoList, err := customerList(s, customerID, name)
+		if err != nil {
+			return err
+		}
+		items, listItem, err := takeListItem(mongoList.Items, item.ID)
+		if err != nil {
+			return err
+		}
+		mongoList.Items = items
+		if err := saveList(s, mongoList); err != nil {
+			return err
+		}
+
+		_, mongoCart, err := unlockedCart(s, customerID)
+		if err != nil {
+			return err
+		}
+		*item = Item{
+			ItemID:    listItem.ItemID,
+			SKU:       listItem.SKU,
+			Quantity:  listItem.Quantity,
+			UnitPrice: item.UnitPrice,
+		}
+		return addItem(s, mongoCart, item)
+	})
+}
+
+func (m *Mongo) SetCoupon(ctx context.Context, customerID string, code string) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		_, mongoCart, err := unlockedCart(s, customerID)
+		if err != nil {
+			return err
+		}
+		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+		_, err = cartsCol.UpdateOne(s, isID(mongoCart.ID), bson.M{"$set": bson.M{"coupon": code, "updatedAt": time.Now()}})
+		return err
+	})
+}
+
+func (m *Mongo) Redemptions(ctx context.Context, code string, customerID string) (int, error) {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	customerObjectID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return 0, err
+	}
+
+	redemption := struct {
+		Count int `bson:"count"`
+	}{}
+	redemptionsCol := m.Client.Database(databaseName).Collection(redemptionsCollectionName)
+	err = redemptionsCol.FindOne(_ctx, bson.M{"code": code, "customer": customerObjectID}).Decode(&redemption)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return redemption.Count, err
+}
+
+func (m *Mongo) Redeem(ctx context.Context, code string, customerID string, limit int) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	customerObjectID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return err
+	}
+
+	return redeem(_ctx, m.Client, code, customerObjectID, limit)
+}
+
+func redeem(ctx context.Context, client *mongo.Client, code string, customerObjectID primitive.ObjectID, limit int) error {
+	redemptionsCol := client.Database(databaseName).Collection(redemptionsCollectionName)
+	filter := bson.M{"code": code, "customer": customerObjectID}
+	if limit > 0 {
+		// at the limit the filter matches nothing, and the upsert collides
+		// with the unique index instead of counting another redemption
+		filter["count"] = bson.M{"$lt": limit}
+	}
+	_, err := redemptionsCol.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"count": 1}}, options.Update().SetUpsert(true))
+	if isDuplicateKey(err) {
+		return ErrPromotionLimitReached
+	}
+	return err
+}
+
+// isDuplicateKey reports whether err is a violation of a unique index.
+func isDuplicateKey(err error) bool {
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		for _, e := range we.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+	var ce mongo.CommandError
+	return errors.As(err, &ce) && ce.Code == 11000
+}
+
+// isIndexNotFound reports whether err is the database refusing to drop an
+// index, or an index of a collection, that does not exist.
+func isIndexNotFound(err error) bool {
+	var ce mongo.CommandError
+	return errors.As(err, &ce) && (ce.Code == 26 || ce.Code == 27)
+}
+
+// LockedUntil reports until when the cart of the customer is locked for
+// checkout. A customer without a cart is not locked.
+func (m *Mongo) LockedUntil(ctx context.Context, customerID string) (time.Time, error) {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	customerObjectID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	customersCol := m.Client.Database(databaseName).Collection(customersCollectionName)
+	mongoCustomer := new(MongoCustomer)
+	if err := customersCol.FindOne(_ctx, isID(customerObjectID)).Decode(mongoCustomer); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	cartsCol := m.Client.Database(databaseName).Collection(cartsCollectionName)
+	mongoCart := new(MongoCart)
+	if err := cartsCol.FindOne(_ctx, isID(mongoCustomer.CartID)).Decode(mongoCart); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return mongoCart.LockedUntil, nil
+}
+
+type MongoSnapshot struct {
+	ID    primitive.ObjectID `bson:"_id"`
+	Value Snapshot           `bson:",inline"`
+}
+
+// CreateSnapshot stores the snapshot and locks the cart it was taken of
+// until it expires, unless the cart changed since or is already locked.
+func (m *Mongo) CreateSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		_, mongoCart, err := customerCart(s, snapshot.CustomerID)
+		if err != nil {
+			return err
+		}
+		if mongoCart.LockedUntil.After(time.Now()) {
+			return ErrCartLocked
+		}
+		if !mongoCart.UpdatedAt.Equal(snapshot.CartUpdatedAt) {
+			return ErrVersionConflict
+		}
+
+		snapshotObjectID := primitive.NewObjectID()
+		snapshot.ID = snapshotObjectID.Hex()
+		snapshot.CartID = mongoCart.ID.Hex()
+		snapshotsCol := s.Client().Database(databaseName).Collection(snapshotsCollectionName)
+		if _, err := snapshotsCol.InsertOne(s, MongoSnapshot{ID: snapshotObjectID, Value: *snapshot}); err != nil {
+			return err
+		}
+
+		cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+		update := bson.M{"$set": bson.M{"checkout": snapshot.ID, "lockedUntil": snapshot.ExpiresAt}}
+		_, err = cartsCol.UpdateOne(s, isID(mongoCart.ID), update)
+		return err
+	})
+}
+
+func (m *Mongo) GetSnapshot(ctx context.Context, id string) (*Snapshot, error) {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	snapshotObjectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotsCol := m.Client.Database(databaseName).Collection(snapshotsCollectionName)
+	mongoSnapshot := new(MongoSnapshot)
+	if err := snapshotsCol.FindOne(_ctx, isID(snapshotObjectID)).Decode(mongoSnapshot); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &mongoSnapshot.Value, nil
+}
+
+// CloseSnapshot moves an open snapshot to status and unlocks its cart.
+func (m *Mongo) CloseSnapshot(ctx context.Context, id string, status SnapshotStatus) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		_, err := closeSnapshot(s, id, status)
+		return err
+	})
+}
+
+// ConsumeSnapshot closes an open snapshot as consumed, redeems its coupon
+// and deletes the cart it was taken of, all or nothing.
+func (m *Mongo) ConsumeSnapshot(ctx context.Context, id string, limit int) error {
+	_ctx, cancel := context.WithTimeout(ctx, time.Duration(5*time.Second))
+	defer cancel()
+
+	return m.withTransaction(_ctx, func(s mongo.SessionContext) error {
+		snapshot, err := closeSnapshot(s, id, SnapshotConsumed)
+		if err != nil {
+			return err
+		}
+		if snapshot.Summary.Coupon != "" {
+			customerObjectID, err := primitive.ObjectIDFromHex(snapshot.CustomerID)
+			if err != nil {
+				return err
+			}
+			if err := redeem(s, s.Client(), snapshot.Summary.Coupon, customerObjectID, limit); err != nil {
+				return err
+			}
+		}
+		if err := removeCart(s, snapshot.CustomerID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+}
+
+// closeSnapshot moves the snapshot to status if it is still open, and
+// unlocks its cart.
+func closeSnapshot(s mongo.SessionContext, id string, status SnapshotStatus) (*Snapshot, error) {
+	snapshotObjectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotsCol := s.Client().Database(databaseName).Collection(snapshotsCollectionName)
+	filter := bson.M{"_id": snapshotObjectID, "status": SnapshotOpen}
+	mongoSnapshot := new(MongoSnapshot)
+	if err := snapshotsCol.FindOneAndUpdate(s, filter, bson.M{"$set": bson.M{"status": status}}).Decode(mongoSnapshot); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSnapshotClosed
+		}
+		return nil, err
+	}
+
+	cartObjectID, err := primitive.ObjectIDFromHex(mongoSnapshot.Value.CartID)
+	if err != nil {
+		return nil, err
+	}
+	cartsCol := s.Client().Database(databaseName).Collection(cartsCollectionName)
+	filter = bson.M{"_id": cartObjectID, "checkout": id}
+	if _, err := cartsCol.UpdateOne(s, filter, bson.M{"$set": bson.M{"checkout": "", "lockedUntil": time.Time{}}}); err != nil {
+		return nil, err
+	}
+	return &mongoSnapshot.Value, nil
+}
@@ -0,0 +1,49 @@
+package cart
+
+import (
+	"context"
+	"testing"
+)
+
+// testDatabases opens each backend of the carts afresh. Mongo is only
+// tested when MONGO_HOST names a replica set to run its transactions on,
+// and its cart database is dropped first.
+func testDatabases(t *testing.T) map[string]func(t *testing.T) Database {
+	t.Helper()
+	databases := map[string]func(t *testing.T) Database{
+		"memory": func(t *testing.T) Database {
+			return NewMemory()
+		},
+	}
+	if host == "" {
+		return databases
+	}
+	databases["mongo"] = func(t *testing.T) Database {
+		t.Helper()
+		db := new(Mongo)
+		if err := db.Init(); err != nil {
+			t.Fatalf("connect to mongo: %v", err)
+		}
+		ctx := context.Background()
+		t.Cleanup(func() { db.Client.Disconnect(ctx) })
+		if err := db.Client.Database(databaseName).Drop(ctx); err != nil {
+			t.Fatalf("drop database: %v", err)
+		}
+		if err := db.EnsureIndexes(ctx); err != nil {
+			t.Fatalf("ensure indexes: %v", err)
+		}
+		return db
+	}
+	return databases
+}
+
+// testCatalogue is a catalogue of the products it holds, by id.
+type testCatalogue map[string]*Product
+
+func (c testCatalogue) GetProduct(ctx context.Context, id string) (*Product, error) {
+	product, ok := c[id]
+	if !ok {
+		return nil, ErrUnknownProduct
+	}
+	return product, nil
+}
@@ -0,0 +1,125 @@
+package cart
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error is the body of every error response of the cart API.
+type Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func invalidID(name string, value string) *Error {
+	return &Error{
+		Status:  fiber.StatusBadRequest,
+		Code:    "invalid_id",
+		Message: fmt.Sprintf("%s %q is not a valid id", name, value),
+	}
+}
+
+func invalidBody(message string) *Error {
+	return &Error{
+		Status:  fiber.StatusBadRequest,
+		Code:    "invalid_body",
+		Message: message,
+	}
+}
+
+func invalidQuantity(quantity int) *Error {
+	return &Error{
+		Status:  fiber.StatusBadRequest,
+		Code:    "invalid_quantity",
+		Message: fmt.Sprintf("quantity %d must be greater than 0", quantity),
+	}
+}
+
+// encodeError maps err to the status and code it is reported with.
+func encodeError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return invalidBody(err.Error())
+	}
+
+	status, code := fiber.StatusInternalServerError, "internal"
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status, code = fiber.StatusNotFound, "not_found"
+	case errors.Is(err, ErrUnknownProduct):
+		status, code = fiber.StatusNotFound, "unknown_product"
+	case errors.Is(err, ErrUnknownList):
+		status, code = fiber.StatusNotFound, "unknown_list"
+	case errors.Is(err, ErrUnknownPromotion):
+		status, code = fiber.StatusNotFound, "unknown_promotion"
+	case errors.Is(err, ErrVariantRequired):
+		status, code = fiber.StatusBadRequest, "variant_required"
+	case errors.Is(err, ErrOutOfStock):
+		status, code = fiber.StatusConflict, "out_of_stock"
+	case errors.Is(err, ErrVersionConflict):
+		status, code = fiber.StatusConflict, "version_conflict"
+	case errors.Is(err, ErrQuantityLimit):
+		status, code = fiber.StatusConflict, "quantity_limit"
+	case errors.Is(err, ErrLineLimit):
+		status, code = fiber.StatusConflict, "line_limit"
+	case errors.Is(err, ErrPromotionInactive):
+		status, code = fiber.StatusConflict, "promotion_inactive"
+	case errors.Is(err, ErrPromotionLimitReached):
+		status, code = fiber.StatusConflict, "promotion_limit_reached"
+	case errors.Is(err, ErrPromotionNotApplicable):
+		status, code = fiber.StatusConflict, "promotion_not_applicable"
+	case errors.Is(err, ErrRegisteredSession):
+		status, code = fiber.StatusForbidden, "registered_session"
+	case errors.Is(err, ErrCartLocked):
+		status, code = fiber.StatusConflict, "cart_locked"
+	case errors.Is(err, ErrEmptyCart):
+		status, code = fiber.StatusConflict, "empty_cart"
+	case errors.Is(err, ErrSnapshotClosed):
+		status, code = fiber.StatusConflict, "snapshot_closed"
+	case errors.Is(err, ErrSnapshotExpired):
+		status, code = fiber.StatusGone, "snapshot_expired"
+	case errors.Is(err, ErrCatalogueUnavailable):
+		status, code = fiber.StatusBadGateway, "catalogue_unavailable"
+	}
+
+	var fe *fiber.Error
+	if status == fiber.StatusInternalServerError && errors.As(err, &fe) {
+		status = fe.Code
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(fe.Code)), " ", "_")
+	}
+
+	message := err.Error()
+	if status == fiber.StatusInternalServerError {
+		message = "internal error"
+	}
+	return &Error{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+func errorHandler(c *fiber.Ctx, err error) error {
+	e := encodeError(err)
+	return c.Status(e.Status).JSON(struct {
+		Error *Error `json:"error"`
+	}{
+		Error: e,
+	})
+}
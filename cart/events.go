@@ -0,0 +1,351 @@
+package cart
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type EventType string
+
+const (
+	EventItemAdded    EventType = "item.added"
+	EventItemUpdated  EventType = "item.updated"
+	EventItemRemoved  EventType = "item.removed"
+	EventCartReplaced EventType = "cart.replaced"
+	EventCartMerged   EventType = "cart.merged"
+	EventCartDeleted  EventType = "cart.deleted"
+	EventCheckedOut   EventType = "cart.checked_out"
+)
+
+var (
+	ErrPublisherFull   = errors.New("event publisher is full")
+	ErrPublisherClosed = errors.New("event publisher is closed")
+)
+
+// Event records a change made to a cart. The item of an item.added event is
+// the line as stored, whose quantity includes what was already in the cart;
+// Quantity is how many were added to it.
+type Event struct {
+	Type       EventType `json:"type"`
+	CustomerID string    `json:"customerID"`
+	SessionID  string    `json:"sessionID,omitempty"`
+	Item       *Item     `json:"item,omitempty"`
+	Items      []Item    `json:"items,omitempty"`
+	Quantity   int       `json:"quantity,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Publisher delivers cart events to a sink.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Publishers fans events out to every publisher, returning the first error.
+type Publishers []Publisher
+
+func (ps Publishers) Publish(ctx context.Context, event Event) error {
+	var first error
+	for _, p := range ps {
+		if err := p.Publish(ctx, event); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// ChannelPublisher hands events to in-process consumers. Events are dropped
+// rather than blocking the cart when the buffer is full.
+type ChannelPublisher struct {
+	events chan Event
+}
+
+func NewChannelPublisher(buffer int) *ChannelPublisher {
+	return &ChannelPublisher{
+		events: make(chan Event, buffer),
+	}
+}
+
+func (p *ChannelPublisher) Events() <-chan Event {
+	return p.events
+}
+
+func (p *ChannelPublisher) Publish(ctx context.Context, event Event) error {
+	select {
+	case p.events <- event:
+		return nil
+	default:
+		return ErrPublisherFull
+	}
+}
+
+// FilePublisher appends events to a file as JSON lines.
+type FilePublisher struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FilePublisher{
+		file: file,
+		enc:  json.NewEncoder(file),
+	}, nil
+}
+
+func (p *FilePublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.enc.Encode(event)
+}
+
+func (p *FilePublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.file.Close()
+}
+
+// WebhookPublisher posts each event as JSON to a URL. Events are queued
+// and posted in the background, so a slow or failing webhook does not hold
+// up the cart; they are dropped when the queue is full.
+type WebhookPublisher struct {
+	url    string
+	client *http.Client
+	logger *zap.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan Event
+	done   chan struct{}
+}
+
+func NewWebhookPublisher(url string, queue int, logger *zap.Logger) *WebhookPublisher {
+	p := &WebhookPublisher{
+		url:    url,
+		client: &http.Client{Timeout: time.Duration(5) * time.Second},
+		queue:  make(chan Event, queue),
+		done:   make(chan struct{}),
+		logger: logger,
+	}
+	go p.run()
+	return p
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPublisherClosed
+	}
+	select {
+	case p.queue <- event:
+		return nil
+	default:
+		return ErrPublisherFull
+	}
+}
+
+// Close stops accepting events and waits for the queued ones to be posted.
+func (p *WebhookPublisher) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+	<-p.done
+	return nil
+}
+
+func (p *WebhookPublisher) run() {
+	defer close(p.done)
+	for event := range p.queue {
+		// the request that made the change is over by now, so the event is
+		// posted on its own context
+		if err := p.post(context.Background(), event); err != nil {
+			p.logger.Error("post event", zap.String("type", string(event.Type)), zap.String("customerID", event.CustomerID), zap.Error(err))
+		}
+	}
+}
+
+func (p *WebhookPublisher) post(ctx context.Context, event Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", res.Status)
+	}
+	return nil
+}
+
+// EventMiddleware publishes an event for every successful change to a cart.
+// Failing to publish is logged and does not fail the change.
+func EventMiddleware(publisher Publisher, logger *zap.Logger) Middleware {
+	return func(next Service) Service {
+		return eventMiddleware{
+			Service:   next,
+			publisher: publisher,
+			logger:    logger,
+		}
+	}
+}
+
+type eventMiddleware struct {
+	Service
+	publisher Publisher
+	logger    *zap.Logger
+}
+
+func (mw eventMiddleware) publish(ctx context.Context, event Event) {
+	event.Time = time.Now()
+	if err := mw.publisher.Publish(ctx, event); err != nil {
+		mw.logger.Error("publish event", zap.String("type", string(event.Type)), zap.String("customerID", event.CustomerID), zap.Error(err))
+	}
+}
+
+func (mw eventMiddleware) DeleteCart(ctx context.Context, customerID string) error {
+	if err := mw.Service.DeleteCart(ctx, customerID); err != nil {
+		return err
+	}
+	mw.publish(ctx, Event{Type: EventCartDeleted, CustomerID: customerID})
+	return nil
+}
+
+func (mw eventMiddleware) MargeCart(ctx context.Context, customerID string, sessionID string) error {
+	if err := mw.Service.MargeCart(ctx, customerID, sessionID); err != nil {
+		return err
+	}
+	mw.publish(ctx, Event{Type: EventCartMerged, CustomerID: customerID, SessionID: sessionID})
+	return nil
+}
+
+func (mw eventMiddleware) CreateItem(ctx context.Context, customerID string, item *Item) error {
+	quantity := addedQuantity(*item)
+	if err := mw.Service.CreateItem(ctx, customerID, item); err != nil {
+		return err
+	}
+	added := *item
+	mw.publish(ctx, Event{Type: EventItemAdded, CustomerID: customerID, Item: &added, Quantity: quantity})
+	return nil
+}
+
+func (mw eventMiddleware) AddItems(ctx context.Context, customerID string, items []Item) (*Cart, error) {
+	cart, err := mw.Service.AddItems(ctx, customerID, items)
+	if err != nil {
+		return nil, err
+	}
+	// the event carries the line as stored, with its id, price and the
+	// quantity it was merged into, once for each product added, along with
+	// the quantity added to it
+	quantities := map[line]int{}
+	for _, item := range items {
+		quantities[item.line()] += addedQuantity(item)
+	}
+	for _, stored := range cart.Items {
+		quantity, ok := quantities[stored.line()]
+		if !ok {
+			continue
+		}
+		added := stored
+		mw.publish(ctx, Event{Type: EventItemAdded, CustomerID: customerID, Item: &added, Quantity: quantity})
+	}
+	return cart, nil
+}
+
+func (mw eventMiddleware) ReplaceItems(ctx context.Context, customerID string, items []Item) (*Cart, error) {
+	cart, err := mw.Service.ReplaceItems(ctx, customerID, items)
+	if err != nil {
+		return nil, err
+	}
+	mw.publish(ctx, Event{Type: EventCartReplaced, CustomerID: customerID, Items: cart.Items})
+	return cart, nil
+}
+
+func (mw eventMiddleware) DeleteItem(ctx context.Context, customerID string, itemID string) error {
+	if err := mw.Service.DeleteItem(ctx, customerID, itemID); err != nil {
+		return err
+	}
+	mw.publish(ctx, Event{Type: EventItemRemoved, CustomerID: customerID, Item: &Item{ID: itemID}})
+	return nil
+}
+
+func (mw eventMiddleware) UpdateItem(ctx context.Context, customerID string, item *Item) error {
+	if err := mw.Service.UpdateItem(ctx, customerID, item); err != nil {
+		return err
+	}
+	updated := *item
+	mw.publish(ctx, Event{Type: EventItemUpdated, CustomerID: customerID, Item: &updated})
+	return nil
+}
+
+func (mw eventMiddleware) MoveToList(ctx context.Context, customerID string, name string, itemID string) error {
+	if err := mw.Service.MoveToList(ctx, customerID, name, itemID); err != nil {
+		return err
+	}
+	mw.publish(ctx, Event{Type: EventItemRemoved, CustomerID: customerID, Item: &Item{ID: itemID}})
+	return nil
+}
+
+func (mw eventMiddleware) MoveToCart(ctx context.Context, customerID string, name string, itemID string) (*Item, error) {
+	list, err := mw.Service.GetList(ctx, customerID, name)
+	if err != nil {
+		return nil, err
+	}
+	quantity := 0
+	for _, listItem := range list.Items {
+		if listItem.ID == itemID {
+			quantity = listItem.Quantity
+		}
+	}
+	item, err := mw.Service.MoveToCart(ctx, customerID, name, itemID)
+	if err != nil {
+		return nil, err
+	}
+	added := *item
+	mw.publish(ctx, Event{Type: EventItemAdded, CustomerID: customerID, Item: &added, Quantity: quantity})
+	return item, nil
+}
+
+// addedQuantity is the quantity an item sent to the cart adds, one if it
+// has none.
+func addedQuantity(item Item) int {
+	if item.Quantity == 0 {
+		return 1
+	}
+	return item.Quantity
+}
+
+func (mw eventMiddleware) ConsumeCheckout(ctx context.Context, customerID string, snapshotID string) error {
+	snapshot, err := mw.Service.GetCheckout(ctx, customerID, snapshotID)
+	if err != nil {
+		return err
+	}
+	if err := mw.Service.ConsumeCheckout(ctx, customerID, snapshotID); err != nil {
+		return err
+	}
+	mw.publish(ctx, Event{Type: EventCheckedOut, CustomerID: customerID, Items: snapshot.Items})
+	return nil
+}
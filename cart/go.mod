@@ -5,5 +5,5 @@ go 1.15
 require (
 	github.com/gofiber/fiber/v2 v2.2.0
 	go.mongodb.org/mongo-driver v1.4.4
-	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
+	go.uber.org/zap v1.16.0
 )
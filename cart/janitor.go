@@ -0,0 +1,31 @@
+package cart
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RunJanitor purges anonymous carts idle for longer than ttl every interval
+// until ctx is done.
+func RunJanitor(ctx context.Context, db Database, ttl, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	total := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			purged, err := db.PurgeCarts(ctx, now.Add(-ttl))
+			if err != nil {
+				logger.Error("purge carts", zap.Error(err))
+				continue
+			}
+			total += purged
+			logger.Info("purge carts", zap.Int("purged", purged), zap.Int("total", total), zap.Duration("ttl", ttl))
+		}
+	}
+}
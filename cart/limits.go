@@ -0,0 +1,52 @@
+package cart
+
+import "errors"
+
+var (
+	ErrQuantityLimit = errors.New("line quantity limit exceeded")
+	ErrLineLimit     = errors.New("cart line limit exceeded")
+)
+
+// Limits bounds the size of a cart. Zero leaves a bound unenforced.
+type Limits struct {
+	MaxQuantity int
+	MaxLines    int
+}
+
+var DefaultLimits = Limits{
+	MaxQuantity: 99,
+	MaxLines:    50,
+}
+
+func (l Limits) check(items []Item) error {
+	if l.MaxLines > 0 && len(items) > l.MaxLines {
+		return ErrLineLimit
+	}
+	for _, item := range items {
+		if l.MaxQuantity > 0 && item.Quantity > l.MaxQuantity {
+			return ErrQuantityLimit
+		}
+	}
+	return nil
+}
+
+// mergeLines returns the lines of items with adding added, one line per
+// product and variant.
+func mergeLines(items []Item, adding ...Item) []Item {
+	lines := make([]Item, len(items), len(items)+len(adding))
+	copy(lines, items)
+	for _, item := range adding {
+		merged := false
+		for i := range lines {
+			if lines[i].line() == item.line() {
+				lines[i].Quantity += item.Quantity
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			lines = append(lines, item)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,55 @@
+package cart
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Names of the secondary lists a customer keeps next to their cart.
+const (
+	ListWishlist      = "wishlist"
+	ListSavedForLater = "saved-for-later"
+)
+
+var ErrUnknownList = errors.New("unknown list")
+
+func validList(name string) error {
+	switch name {
+	case ListWishlist, ListSavedForLater:
+		return nil
+	}
+	return ErrUnknownList
+}
+
+// mergeListItem adds the item to the list, adding its quantity to the entry of
+// the same product and variant if there is one. The item is updated to the stored entry.
+func mergeListItem(items []Item, item *Item) []Item {
+	for i := range items {
+		if items[i].line() == item.line() {
+			items[i].Quantity += item.Quantity
+			items[i].UnitPrice = item.UnitPrice
+			*item = items[i]
+			return items
+		}
+	}
+	*item = Item{
+		ID:        primitive.NewObjectID().Hex(),
+		ItemID:    item.ItemID,
+		SKU:       item.SKU,
+		Quantity:  item.Quantity,
+		UnitPrice: item.UnitPrice,
+	}
+	return append(items, *item)
+}
+
+// takeListItem removes the entry with the given ID from the list and
+// returns it.
+func takeListItem(items []Item, itemID string) ([]Item, Item, error) {
+	for i, item := range items {
+		if item.ID == itemID {
+			return append(items[:i:i], items[i+1:]...), item, nil
+		}
+	}
+	return items, Item{}, ErrNotFound
+}
@@ -0,0 +1,198 @@
+package cart
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func LoggingMiddleware(logger *zap.Logger) Middleware {
+	return func(next Service) Service {
+		return loggingMiddleware{
+			next:   next,
+			logger: logger,
+		}
+	}
+}
+
+type loggingMiddleware struct {
+	next   Service
+	logger *zap.Logger
+}
+
+func (mw loggingMiddleware) GetCart(ctx context.Context, customerID string) (cart *Cart, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method GetCart", zap.String("customerID", customerID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.GetCart(ctx, customerID)
+}
+
+func (mw loggingMiddleware) GetSummary(ctx context.Context, customerID string) (summary *Summary, err error) {
+	defer func(begin time.Time) {
+		total := 0.0
+		if summary != nil {
+			total = summary.Total
+		}
+		mw.logger.Info("method GetSummary", zap.String("customerID", customerID), zap.Float64("total", total), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.GetSummary(ctx, customerID)
+}
+
+func (mw loggingMiddleware) ApplyPromotion(ctx context.Context, customerID string, code string) (summary *Summary, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method ApplyPromotion", zap.String("customerID", customerID), zap.String("code", code), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.ApplyPromotion(ctx, customerID, code)
+}
+
+func (mw loggingMiddleware) RemovePromotion(ctx context.Context, customerID string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method RemovePromotion", zap.String("customerID", customerID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.RemovePromotion(ctx, customerID)
+}
+
+func (mw loggingMiddleware) RedeemPromotion(ctx context.Context, customerID string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method RedeemPromotion", zap.String("customerID", customerID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.RedeemPromotion(ctx, customerID)
+}
+
+func (mw loggingMiddleware) DeleteCart(ctx context.Context, customerID string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method DeleteCart", zap.String("customerID", customerID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.DeleteCart(ctx, customerID)
+}
+
+func (mw loggingMiddleware) MargeCart(ctx context.Context, customerID string, sessionID string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method MargeCart", zap.String("customerID", customerID), zap.String("sessionID", sessionID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.MargeCart(ctx, customerID, sessionID)
+}
+
+func (mw loggingMiddleware) GetItem(ctx context.Context, customerID string, itemID string) (item *Item, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method GetItem", zap.String("customerID", customerID), zap.String("itemID", itemID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.GetItem(ctx, customerID, itemID)
+}
+
+func (mw loggingMiddleware) GetItems(ctx context.Context, customerID string) (items *[]Item, err error) {
+	defer func(begin time.Time) {
+		n := 0
+		if items != nil {
+			n = len(*items)
+		}
+		mw.logger.Info("method GetItems", zap.String("customerID", customerID), zap.Int("result", n), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.GetItems(ctx, customerID)
+}
+
+func (mw loggingMiddleware) CreateItem(ctx context.Context, customerID string, item *Item) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method CreateItem", zap.String("customerID", customerID), zap.String("itemID", item.ID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.CreateItem(ctx, customerID, item)
+}
+
+func (mw loggingMiddleware) AddItems(ctx context.Context, customerID string, items []Item) (cart *Cart, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method AddItems", zap.String("customerID", customerID), zap.Int("items", len(items)), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.AddItems(ctx, customerID, items)
+}
+
+func (mw loggingMiddleware) ReplaceItems(ctx context.Context, customerID string, items []Item) (cart *Cart, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method ReplaceItems", zap.String("customerID", customerID), zap.Int("items", len(items)), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.ReplaceItems(ctx, customerID, items)
+}
+
+func (mw loggingMiddleware) DeleteItem(ctx context.Context, customerID string, itemID string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method DeleteItem", zap.String("customerID", customerID), zap.String("itemID", itemID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.DeleteItem(ctx, customerID, itemID)
+}
+
+func (mw loggingMiddleware) UpdateItem(ctx context.Context, customerID string, item *Item) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method UpdateItem", zap.String("customerID", customerID), zap.String("itemID", item.ID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.UpdateItem(ctx, customerID, item)
+}
+
+func (mw loggingMiddleware) GetList(ctx context.Context, customerID string, name string) (list *List, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method GetList", zap.String("customerID", customerID), zap.String("list", name), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.GetList(ctx, customerID, name)
+}
+
+func (mw loggingMiddleware) AddListItem(ctx context.Context, customerID string, name string, item *Item) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method AddListItem", zap.String("customerID", customerID), zap.String("list", name), zap.String("itemID", item.ID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.AddListItem(ctx, customerID, name, item)
+}
+
+func (mw loggingMiddleware) DeleteListItem(ctx context.Context, customerID string, name string, itemID string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method DeleteListItem", zap.String("customerID", customerID), zap.String("list", name), zap.String("itemID", itemID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.DeleteListItem(ctx, customerID, name, itemID)
+}
+
+func (mw loggingMiddleware) MoveToList(ctx context.Context, customerID string, name string, itemID string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method MoveToList", zap.String("customerID", customerID), zap.String("list", name), zap.String("itemID", itemID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.MoveToList(ctx, customerID, name, itemID)
+}
+
+func (mw loggingMiddleware) MoveToCart(ctx context.Context, customerID string, name string, itemID string) (item *Item, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method MoveToCart", zap.String("customerID", customerID), zap.String("list", name), zap.String("itemID", itemID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.MoveToCart(ctx, customerID, name, itemID)
+}
+
+func (mw loggingMiddleware) Checkout(ctx context.Context, customerID string) (snapshot *Snapshot, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method Checkout", zap.String("customerID", customerID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.Checkout(ctx, customerID)
+}
+
+func (mw loggingMiddleware) GetCheckout(ctx context.Context, customerID string, snapshotID string) (snapshot *Snapshot, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method GetCheckout", zap.String("customerID", customerID), zap.String("snapshotID", snapshotID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.GetCheckout(ctx, customerID, snapshotID)
+}
+
+func (mw loggingMiddleware) ConsumeCheckout(ctx context.Context, customerID string, snapshotID string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method ConsumeCheckout", zap.String("customerID", customerID), zap.String("snapshotID", snapshotID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.ConsumeCheckout(ctx, customerID, snapshotID)
+}
+
+func (mw loggingMiddleware) ReleaseCheckout(ctx context.Context, customerID string, snapshotID string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method ReleaseCheckout", zap.String("customerID", customerID), zap.String("snapshotID", snapshotID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.ReleaseCheckout(ctx, customerID, snapshotID)
+}
+
+func (mw loggingMiddleware) Ping(ctx context.Context) (health []HealthCheck) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method Ping", zap.Int("result", len(health)), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.Ping(ctx)
+}
@@ -0,0 +1,590 @@
+package cart
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryCart struct {
+	ID          string
+	Items       []Item
+	Coupon      string
+	Checkout    string
+	LockedUntil time.Time
+	Anonymous   bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type listKey struct {
+	customerID string
+	name       string
+}
+
+type redemptionKey struct {
+	code       string
+	customerID string
+}
+
+type Memory struct {
+	mu          sync.RWMutex
+	carts       map[string]*memoryCart
+	lists       map[listKey]*List
+	redemptions map[redemptionKey]int
+	snapshots   map[string]*Snapshot
+	// registered holds the customers who merged a session cart on login,
+	// whose carts are never anonymous.
+	registered map[string]bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		carts:       map[string]*memoryCart{},
+		lists:       map[listKey]*List{},
+		redemptions: map[redemptionKey]int{},
+		snapshots:   map[string]*Snapshot{},
+		registered:  map[string]bool{},
+	}
+}
+
+func (m *Memory) Init() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.carts == nil {
+		m.carts = map[string]*memoryCart{}
+	}
+	if m.lists == nil {
+		m.lists = map[listKey]*List{}
+	}
+	if m.redemptions == nil {
+		m.redemptions = map[redemptionKey]int{}
+	}
+	if m.snapshots == nil {
+		m.snapshots = map[string]*Snapshot{}
+	}
+	if m.registered == nil {
+		m.registered = map[string]bool{}
+	}
+	return nil
+}
+
+func (m *Memory) cart(customerID string) (*memoryCart, error) {
+	c, ok := m.carts[customerID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+// unlockedCart is cartOrCreate for a change to the cart, which fails with
+// ErrCartLocked while an open checkout snapshot locks the cart.
+func (m *Memory) unlockedCart(customerID string) (*memoryCart, error) {
+	c := m.cartOrCreate(customerID)
+	if c.locked() {
+		return nil, ErrCartLocked
+	}
+	return c, nil
+}
+
+func (c *memoryCart) locked() bool {
+	return c.LockedUntil.After(time.Now())
+}
+
+func (m *Memory) cartOrCreate(customerID string) *memoryCart {
+	c, ok := m.carts[customerID]
+	if !ok {
+		now := time.Now()
+		c = &memoryCart{
+			ID:        primitive.NewObjectID().Hex(),
+			Items:     []Item{},
+			Anonymous: !m.registered[customerID],
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		m.carts[customerID] = c
+	}
+	return c
+}
+
+func (m *Memory) GetCart(ctx context.Context, customerID string) (*Cart, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.cartOrCreate(customerID)
+	items := make([]Item, len(c.Items))
+	copy(items, c.Items)
+	return &Cart{
+		ID:          c.ID,
+		CustomerID:  customerID,
+		Items:       items,
+		Coupon:      c.Coupon,
+		Checkout:    c.Checkout,
+		LockedUntil: c.LockedUntil,
+		CreatedAt:   c.CreatedAt,
+		UpdatedAt:   c.UpdatedAt,
+	}, nil
+}
+
+func (m *Memory) DeleteCart(ctx context.Context, customerID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.cart(customerID)
+	if err != nil {
+		return err
+	}
+	if c.locked() {
+		return ErrCartLocked
+	}
+	delete(m.carts, customerID)
+	return nil
+}
+
+func (m *Memory) MargeCart(ctx context.Context, customerID string, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if customerID == sessionID {
+		return nil
+	}
+
+	c, err := m.unlockedCart(customerID)
+	if err != nil {
+		return err
+	}
+	if m.registered[sessionID] {
+		return ErrRegisteredSession
+	}
+	session, ok := m.carts[sessionID]
+	if ok && session.locked() {
+		return ErrCartLocked
+	}
+	m.registered[customerID] = true
+	c.Anonymous = false
+	c.UpdatedAt = time.Now()
+
+	for key, sessionList := range m.lists {
+		if key.customerID != sessionID {
+			continue
+		}
+		l := m.listOrCreate(customerID, key.name)
+		for i := range sessionList.Items {
+			l.Items = mergeListItem(l.Items, &sessionList.Items[i])
+		}
+		l.UpdatedAt = time.Now()
+		delete(m.lists, key)
+	}
+
+	if !ok {
+		return nil
+	}
+	for _, item := range session.Items {
+		merged := false
+		for i := range c.Items {
+			if c.Items[i].line() == item.line() {
+				c.Items[i].Quantity += item.Quantity
+				c.Items[i].Version++
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			item.CartID = c.ID
+			c.Items = append(c.Items, item)
+		}
+	}
+	delete(m.carts, sessionID)
+	return nil
+}
+
+func (m *Memory) SetCoupon(ctx context.Context, customerID string, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.unlockedCart(customerID)
+	if err != nil {
+		return err
+	}
+	c.Coupon = code
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+func (m *Memory) GetItem(ctx context.Context, customerID string, itemID string) (*Item, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, err := m.cart(customerID)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range c.Items {
+		if item.ID == itemID {
+			return &item, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) GetItems(ctx context.Context, customerID string) (*[]Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.cartOrCreate(customerID)
+	items := make([]Item, len(c.Items))
+	copy(items, c.Items)
+	return &items, nil
+}
+
+func (m *Memory) CreateItem(ctx context.Context, customerID string, item *Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.unlockedCart(customerID)
+	if err != nil {
+		return err
+	}
+	m.addItem(c, item)
+	return nil
+}
+
+func (m *Memory) CreateItems(ctx context.Context, customerID string, items []Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.unlockedCart(customerID)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		m.addItem(c, &items[i])
+	}
+	return nil
+}
+
+func (m *Memory) ReplaceItems(ctx context.Context, customerID string, items []Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.unlockedCart(customerID)
+	if err != nil {
+		return err
+	}
+	c.Items = make([]Item, 0, len(items))
+	for i := range items {
+		items[i].ID = primitive.NewObjectID().Hex()
+		items[i].CartID = c.ID
+		items[i].Version = 1
+		c.Items = append(c.Items, items[i])
+	}
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+func (m *Memory) addItem(c *memoryCart, item *Item) {
+	for i := range c.Items {
+		if c.Items[i].line() == item.line() {
+			c.Items[i].Quantity += item.Quantity
+			c.Items[i].UnitPrice = item.UnitPrice
+			c.Items[i].Version++
+			c.UpdatedAt = time.Now()
+			*item = c.Items[i]
+			return
+		}
+	}
+	item.ID = primitive.NewObjectID().Hex()
+	item.CartID = c.ID
+	item.Version = 1
+	c.Items = append(c.Items, *item)
+	c.UpdatedAt = time.Now()
+}
+
+func (m *Memory) DeleteItem(ctx context.Context, customerID string, itemID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.cart(customerID)
+	if err != nil {
+		return err
+	}
+	if c.locked() {
+		return ErrCartLocked
+	}
+	for i, item := range c.Items {
+		if item.ID == itemID {
+			c.Items = append(c.Items[:i], c.Items[i+1:]...)
+			c.UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) UpdateItem(ctx context.Context, customerID string, item *Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.cart(customerID)
+	if err != nil {
+		return err
+	}
+	if c.locked() {
+		return ErrCartLocked
+	}
+	for i := range c.Items {
+		if c.Items[i].ID == item.ID {
+			if item.Version != 0 && item.Version != c.Items[i].Version {
+				return ErrVersionConflict
+			}
+			c.Items[i].Quantity = item.Quantity
+			c.Items[i].UnitPrice = item.UnitPrice
+			c.Items[i].Version++
+			c.UpdatedAt = time.Now()
+			*item = c.Items[i]
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) listOrCreate(customerID string, name string) *List {
+	key := listKey{customerID, name}
+	l, ok := m.lists[key]
+	if !ok {
+		l = &List{
+			Name:       name,
+			CustomerID: customerID,
+			Items:      []Item{},
+		}
+		m.lists[key] = l
+	}
+	return l
+}
+
+func (m *Memory) GetList(ctx context.Context, customerID string, name string) (*List, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	l, ok := m.lists[listKey{customerID, name}]
+	if !ok {
+		return &List{Name: name, CustomerID: customerID, Items: []Item{}}, nil
+	}
+	items := make([]Item, len(l.Items))
+	copy(items, l.Items)
+	return &List{
+		Name:       l.Name,
+		CustomerID: l.CustomerID,
+		Items:      items,
+		UpdatedAt:  l.UpdatedAt,
+	}, nil
+}
+
+func (m *Memory) AddListItem(ctx context.Context, customerID string, name string, item *Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.listOrCreate(customerID, name)
+	l.Items = mergeListItem(l.Items, item)
+	l.UpdatedAt = time.Now()
+	return nil
+}
+
+func (m *Memory) DeleteListItem(ctx context.Context, customerID string, name string, itemID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.listOrCreate(customerID, name)
+	items, _, err := takeListItem(l.Items, itemID)
+	if err != nil {
+		return err
+	}
+	l.Items = items
+	l.UpdatedAt = time.Now()
+	return nil
+}
+
+func (m *Memory) MoveToList(ctx context.Context, customerID string, name string, itemID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.cart(customerID)
+	if err != nil {
+		return err
+	}
+	if c.locked() {
+		return ErrCartLocked
+	}
+	for i, item := range c.Items {
+		if item.ID == itemID {
+			c.Items = append(c.Items[:i], c.Items[i+1:]...)
+			c.UpdatedAt = time.Now()
+
+			l := m.listOrCreate(customerID, name)
+			l.Items = mergeListItem(l.Items, &item)
+			l.UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) MoveToCart(ctx context.Context, customerID string, name string, item *Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.unlockedCart(customerID)
+	if err != nil {
+		return err
+	}
+	l := m.listOrCreate(customerID, name)
+	items, listItem, err := takeListItem(l.Items, item.ID)
+	if err != nil {
+		return err
+	}
+	l.Items = items
+	l.UpdatedAt = time.Now()
+
+	*item = Item{
+		ItemID:    listItem.ItemID,
+		SKU:       listItem.SKU,
+		Quantity:  listItem.Quantity,
+		UnitPrice: item.UnitPrice,
+	}
+	m.addItem(c, item)
+	return nil
+}
+
+func (m *Memory) LockedUntil(ctx context.Context, customerID string) (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.carts[customerID]
+	if !ok {
+		return time.Time{}, nil
+	}
+	return c.LockedUntil, nil
+}
+
+func (m *Memory) CreateSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.cartOrCreate(snapshot.CustomerID)
+	if c.LockedUntil.After(time.Now()) {
+		return ErrCartLocked
+	}
+	if !c.UpdatedAt.Equal(snapshot.CartUpdatedAt) {
+		return ErrVersionConflict
+	}
+	snapshot.ID = primitive.NewObjectID().Hex()
+	snapshot.CartID = c.ID
+	stored := *snapshot
+	stored.Items = make([]Item, len(snapshot.Items))
+	copy(stored.Items, snapshot.Items)
+	m.snapshots[snapshot.ID] = &stored
+
+	c.Checkout = snapshot.ID
+	c.LockedUntil = snapshot.ExpiresAt
+	return nil
+}
+
+func (m *Memory) GetSnapshot(ctx context.Context, id string) (*Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.snapshots[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	snapshot := *s
+	snapshot.Items = make([]Item, len(s.Items))
+	copy(snapshot.Items, s.Items)
+	return &snapshot, nil
+}
+
+func (m *Memory) CloseSnapshot(ctx context.Context, id string, status SnapshotStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.snapshots[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.Status != SnapshotOpen {
+		return ErrSnapshotClosed
+	}
+	s.Status = status
+	if c, ok := m.carts[s.CustomerID]; ok && c.Checkout == id {
+		c.Checkout = ""
+		c.LockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (m *Memory) ConsumeSnapshot(ctx context.Context, id string, limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.snapshots[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.Status != SnapshotOpen {
+		return ErrSnapshotClosed
+	}
+	key := redemptionKey{s.Summary.Coupon, s.CustomerID}
+	if s.Summary.Coupon != "" {
+		if limit > 0 && m.redemptions[key] >= limit {
+			return ErrPromotionLimitReached
+		}
+		m.redemptions[key]++
+	}
+	s.Status = SnapshotConsumed
+	delete(m.carts, s.CustomerID)
+	return nil
+}
+
+func (m *Memory) Redemptions(ctx context.Context, code string, customerID string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.redemptions[redemptionKey{code, customerID}], nil
+}
+
+func (m *Memory) Redeem(ctx context.Context, code string, customerID string, limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := redemptionKey{code, customerID}
+	if limit > 0 && m.redemptions[key] >= limit {
+		return ErrPromotionLimitReached
+	}
+	m.redemptions[key]++
+	return nil
+}
+
+func (m *Memory) PurgeCarts(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for customerID, c := range m.carts {
+		if c.Anonymous && c.UpdatedAt.Before(before) {
+			delete(m.carts, customerID)
+			for key := range m.lists {
+				if key.customerID == customerID {
+					delete(m.lists, key)
+				}
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
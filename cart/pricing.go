@@ -0,0 +1,55 @@
+package cart
+
+import "math"
+
+// PricingPolicy holds the parameters used to turn cart lines into totals.
+type PricingPolicy struct {
+	Shipping         float64
+	FreeShippingOver float64
+	TaxRate          float64
+	Promotions       Promotions
+}
+
+var DefaultPricingPolicy = PricingPolicy{
+	Shipping: 4.99,
+}
+
+// Summarise prices the items according to the policy and the promotion, if
+// any. Shipping is waived when FreeShippingOver is set and the subtotal
+// reaches it, and tax is charged on the discounted subtotal only.
+func (p PricingPolicy) Summarise(items []Item, promotion *Promotion) Summary {
+	summary := Summary{
+		Lines: make([]LineTotal, 0, len(items)),
+	}
+	for _, item := range items {
+		total := round(float64(item.Quantity) * item.UnitPrice)
+		summary.Lines = append(summary.Lines, LineTotal{
+			ID:        item.ID,
+			ItemID:    item.ItemID,
+			SKU:       item.SKU,
+			Quantity:  item.Quantity,
+			UnitPrice: item.UnitPrice,
+			Total:     total,
+		})
+		summary.Subtotal += total
+	}
+	summary.Subtotal = round(summary.Subtotal)
+
+	freeShipping := false
+	if promotion != nil {
+		summary.Coupon = promotion.Code
+		summary.Discount, freeShipping = promotion.Discount(items, summary.Subtotal)
+	}
+
+	if len(items) > 0 && !freeShipping && !(p.FreeShippingOver > 0 && summary.Subtotal >= p.FreeShippingOver) {
+		summary.Shipping = p.Shipping
+	}
+	discounted := summary.Subtotal - summary.Discount
+	summary.Tax = round(discounted * p.TaxRate)
+	summary.Total = round(discounted + summary.Shipping + summary.Tax)
+	return summary
+}
+
+func round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,156 @@
+package cart
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+type PromotionKind string
+
+const (
+	PromotionPercentage   PromotionKind = "percentage"
+	PromotionFixed        PromotionKind = "fixed"
+	PromotionFreeShipping PromotionKind = "free-shipping"
+	PromotionBuyXGetY     PromotionKind = "buy-x-get-y"
+)
+
+var (
+	ErrUnknownPromotion       = errors.New("unknown promotion")
+	ErrPromotionInactive      = errors.New("promotion is not active")
+	ErrPromotionLimitReached  = errors.New("promotion usage limit reached")
+	ErrPromotionNotApplicable = errors.New("promotion does not apply to cart")
+	ErrInvalidPromotion       = errors.New("invalid promotion")
+)
+
+// Promotion is a discount rule redeemable with a coupon code.
+//
+// Value is a percentage for percentage promotions and an amount for fixed
+// ones. A buy-x-get-y promotion makes Get of every Buy+Get units free.
+// ItemID restricts percentage and buy-x-get-y promotions to one product.
+// Zero StartsAt, EndsAt and UsageLimit leave the promotion unbounded.
+type Promotion struct {
+	Code        string        `json:"code"`
+	Kind        PromotionKind `json:"kind"`
+	Value       float64       `json:"value"`
+	ItemID      string        `json:"itemID"`
+	Buy         int           `json:"buy"`
+	Get         int           `json:"get"`
+	MinSubtotal float64       `json:"minSubtotal"`
+	StartsAt    time.Time     `json:"startsAt"`
+	EndsAt      time.Time     `json:"endsAt"`
+	UsageLimit  int           `json:"usageLimit"`
+}
+
+// Promotions holds the promotions on offer by coupon code.
+type Promotions map[string]Promotion
+
+// LoadPromotions reads a JSON array of promotions from path, rejecting any
+// that could not be applied sensibly.
+func LoadPromotions(path string) (Promotions, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := []Promotion{}
+	if err := json.NewDecoder(f).Decode(&list); err != nil {
+		return nil, err
+	}
+	promotions := Promotions{}
+	for _, p := range list {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		promotions[p.Code] = p
+	}
+	return promotions, nil
+}
+
+// Validate checks the promotion has a code, a known kind and a value and
+// counts in range for its kind.
+func (p Promotion) Validate() error {
+	if p.Code == "" {
+		return fmt.Errorf("%w: no code", ErrInvalidPromotion)
+	}
+	if p.MinSubtotal < 0 || p.UsageLimit < 0 {
+		return fmt.Errorf("%w %q: negative minimum subtotal or usage limit", ErrInvalidPromotion, p.Code)
+	}
+	switch p.Kind {
+	case PromotionPercentage:
+		if p.Value <= 0 || p.Value > 100 {
+			return fmt.Errorf("%w %q: percentage must be above 0 and at most 100", ErrInvalidPromotion, p.Code)
+		}
+	case PromotionFixed, PromotionFreeShipping:
+		if p.Value < 0 {
+			return fmt.Errorf("%w %q: value must not be negative", ErrInvalidPromotion, p.Code)
+		}
+	case PromotionBuyXGetY:
+		if p.Buy <= 0 || p.Get <= 0 {
+			return fmt.Errorf("%w %q: buy and get must be positive", ErrInvalidPromotion, p.Code)
+		}
+	default:
+		return fmt.Errorf("%w %q: unknown kind %q", ErrInvalidPromotion, p.Code, p.Kind)
+	}
+	return nil
+}
+
+// Active reports whether the promotion can be redeemed at t.
+func (p Promotion) Active(t time.Time) bool {
+	if !p.StartsAt.IsZero() && t.Before(p.StartsAt) {
+		return false
+	}
+	if !p.EndsAt.IsZero() && !t.Before(p.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// Discount computes what the promotion takes off items worth subtotal, never
+// more than subtotal, and whether it waives shipping.
+func (p Promotion) Discount(items []Item, subtotal float64) (float64, bool) {
+	discount, freeShipping := p.discount(items, subtotal)
+	return math.Max(0, math.Min(discount, subtotal)), freeShipping
+}
+
+func (p Promotion) discount(items []Item, subtotal float64) (float64, bool) {
+	if subtotal < p.MinSubtotal {
+		return 0, false
+	}
+
+	switch p.Kind {
+	case PromotionPercentage:
+		base := subtotal
+		if p.ItemID != "" {
+			base = 0
+			for _, item := range items {
+				if item.ItemID == p.ItemID {
+					base += float64(item.Quantity) * item.UnitPrice
+				}
+			}
+		}
+		return round(base * p.Value / 100), false
+	case PromotionFixed:
+		return round(math.Min(p.Value, subtotal)), false
+	case PromotionFreeShipping:
+		return 0, true
+	case PromotionBuyXGetY:
+		if p.Buy <= 0 || p.Get <= 0 {
+			return 0, false
+		}
+		discount := 0.0
+		for _, item := range items {
+			if p.ItemID != "" && item.ItemID != p.ItemID {
+				continue
+			}
+			free := item.Quantity / (p.Buy + p.Get) * p.Get
+			discount += float64(free) * item.UnitPrice
+		}
+		return round(discount), false
+	}
+	return 0, false
+}
@@ -2,70 +2,516 @@ package cart
 
 import (
 	"context"
+	"errors"
 	"time"
+
+	"go.uber.org/zap"
 )
 
 type Service interface {
 	GetCart(ctx context.Context, customerID string) (*Cart, error)
+	GetSummary(ctx context.Context, customerID string) (*Summary, error)
+	ApplyPromotion(ctx context.Context, customerID string, code string) (*Summary, error)
+	RemovePromotion(ctx context.Context, customerID string) error
+	RedeemPromotion(ctx context.Context, customerID string) error
 	DeleteCart(ctx context.Context, customerID string) error
 	MargeCart(ctx context.Context, customerID string, sessionID string) error
 	GetItem(ctx context.Context, customerID string, itemID string) (*Item, error)
 	GetItems(ctx context.Context, customerID string) (*[]Item, error)
 	CreateItem(ctx context.Context, customerID string, item *Item) error
+	AddItems(ctx context.Context, customerID string, items []Item) (*Cart, error)
+	ReplaceItems(ctx context.Context, customerID string, items []Item) (*Cart, error)
 	DeleteItem(ctx context.Context, customerID string, itemID string) error
 	UpdateItem(ctx context.Context, customerID string, item *Item) error
+	GetList(ctx context.Context, customerID string, name string) (*List, error)
+	AddListItem(ctx context.Context, customerID string, name string, item *Item) error
+	DeleteListItem(ctx context.Context, customerID string, name string, itemID string) error
+	MoveToList(ctx context.Context, customerID string, name string, itemID string) error
+	MoveToCart(ctx context.Context, customerID string, name string, itemID string) (*Item, error)
+	Checkout(ctx context.Context, customerID string) (*Snapshot, error)
+	GetCheckout(ctx context.Context, customerID string, snapshotID string) (*Snapshot, error)
+	ConsumeCheckout(ctx context.Context, customerID string, snapshotID string) error
+	ReleaseCheckout(ctx context.Context, customerID string, snapshotID string) error
 	Ping(ctx context.Context) []HealthCheck
 }
 
-type service struct{}
+type Middleware func(Service) Service
+
+type service struct {
+	db          Database
+	catalogue   Catalogue
+	pricing     PricingPolicy
+	limits      Limits
+	checkoutTTL time.Duration
+	logger      *zap.Logger
+}
+
+func NewService(db Database, catalogue Catalogue, pricing PricingPolicy, limits Limits, checkoutTTL time.Duration, logger *zap.Logger) Service {
+	return &service{
+		db:          db,
+		catalogue:   catalogue,
+		pricing:     pricing,
+		limits:      limits,
+		checkoutTTL: checkoutTTL,
+		logger:      logger,
+	}
+}
+
+// checkUnlocked rejects changes to the cart of the customer while it is
+// locked by an open checkout snapshot, before the catalogue is asked to
+// price them. The database checks the lock again as it makes the change.
+func (s *service) checkUnlocked(ctx context.Context, customerID string) error {
+	lockedUntil, err := s.db.LockedUntil(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	if lockedUntil.After(time.Now()) {
+		return ErrCartLocked
+	}
+	return nil
+}
+
+// checkLimits checks the cart of the customer stays within limits once
+// adding is added to it.
+func (s *service) checkLimits(ctx context.Context, customerID string, adding ...Item) error {
+	items, err := s.db.GetItems(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	return s.limits.check(mergeLines(*items, adding...))
+}
+
+// price sets the unit price of the item from the catalogue, rejecting
+// products or variants that are unknown or out of stock.
+func (s *service) price(ctx context.Context, item *Item) error {
+	product, err := s.catalogue.GetProduct(ctx, item.ItemID)
+	if err != nil {
+		return err
+	}
+	variant, err := product.Variant(item.SKU)
+	if err != nil {
+		return err
+	}
+	if variant.Count <= 0 {
+		return ErrOutOfStock
+	}
+	item.UnitPrice = variant.Price
+	return nil
+}
 
 func (s *service) GetCart(ctx context.Context, customerID string) (*Cart, error) {
-	return db.GetCart(ctx, customerID)
+	return s.db.GetCart(ctx, customerID)
+}
+
+func (s *service) GetSummary(ctx context.Context, customerID string) (*Summary, error) {
+	cart, err := s.db.GetCart(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var promotion *Promotion
+	if cart.Coupon != "" {
+		// a coupon that has since expired or run out is priced without
+		promotion, err = s.promotion(ctx, customerID, cart.Coupon)
+		if err != nil && !isPromotionError(err) {
+			return nil, err
+		}
+	}
+
+	summary := s.pricing.Summarise(cart.Items, promotion)
+	return &summary, nil
+}
+
+func (s *service) ApplyPromotion(ctx context.Context, customerID string, code string) (*Summary, error) {
+	if err := s.checkUnlocked(ctx, customerID); err != nil {
+		return nil, err
+	}
+	promotion, err := s.promotion(ctx, customerID, code)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.db.GetItems(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := s.pricing.Summarise(*items, promotion)
+	if _, freeShipping := promotion.Discount(*items, summary.Subtotal); summary.Discount == 0 && !freeShipping {
+		return nil, ErrPromotionNotApplicable
+	}
+	if err := s.db.SetCoupon(ctx, customerID, code); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+func (s *service) RemovePromotion(ctx context.Context, customerID string) error {
+	if err := s.checkUnlocked(ctx, customerID); err != nil {
+		return err
+	}
+	return s.db.SetCoupon(ctx, customerID, "")
+}
+
+// RedeemPromotion counts the coupon of the cart against the customer's usage
+// limit once the order it discounted has been paid, and takes it off the cart.
+func (s *service) RedeemPromotion(ctx context.Context, customerID string) error {
+	cart, err := s.db.GetCart(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	if cart.Coupon == "" {
+		return nil
+	}
+	promotion, err := s.promotion(ctx, customerID, cart.Coupon)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Redeem(ctx, cart.Coupon, customerID, promotion.UsageLimit); err != nil {
+		return err
+	}
+	return s.db.SetCoupon(ctx, customerID, "")
+}
+
+// promotion looks up the promotion of the coupon code and checks the customer
+// may still redeem it.
+func (s *service) promotion(ctx context.Context, customerID string, code string) (*Promotion, error) {
+	promotion, ok := s.pricing.Promotions[code]
+	if !ok {
+		return nil, ErrUnknownPromotion
+	}
+	if !promotion.Active(time.Now()) {
+		return nil, ErrPromotionInactive
+	}
+	if promotion.UsageLimit > 0 {
+		n, err := s.db.Redemptions(ctx, code, customerID)
+		if err != nil {
+			return nil, err
+		}
+		if n >= promotion.UsageLimit {
+			return nil, ErrPromotionLimitReached
+		}
+	}
+	return &promotion, nil
+}
+
+func isPromotionError(err error) bool {
+	return errors.Is(err, ErrUnknownPromotion) ||
+		errors.Is(err, ErrPromotionInactive) ||
+		errors.Is(err, ErrPromotionLimitReached) ||
+		errors.Is(err, ErrPromotionNotApplicable)
 }
 
 func (s *service) DeleteCart(ctx context.Context, customerID string) error {
-	return db.DeleteCart(ctx, customerID)
+	if err := s.checkUnlocked(ctx, customerID); err != nil {
+		return err
+	}
+	return s.db.DeleteCart(ctx, customerID)
 }
 
 func (s *service) MargeCart(ctx context.Context, customerID string, sessionID string) error {
-	return db.MargeCart(ctx, customerID, sessionID)
+	if err := s.checkUnlocked(ctx, customerID); err != nil {
+		return err
+	}
+	if err := s.checkUnlocked(ctx, sessionID); err != nil {
+		return err
+	}
+	return s.db.MargeCart(ctx, customerID, sessionID)
 }
 
 func (s *service) GetItem(ctx context.Context, customerID string, itemID string) (*Item, error) {
-	return db.GetItem(ctx, customerID, itemID)
+	return s.db.GetItem(ctx, customerID, itemID)
 }
 
 func (s *service) GetItems(ctx context.Context, customerID string) (*[]Item, error) {
-	return db.GetItems(ctx, customerID)
+	return s.db.GetItems(ctx, customerID)
 }
 
 func (s *service) CreateItem(ctx context.Context, customerID string, item *Item) error {
-	return db.CreateItem(ctx, customerID, item)
+	if err := s.checkUnlocked(ctx, customerID); err != nil {
+		return err
+	}
+	if item.Quantity == 0 {
+		item.Quantity = 1
+	}
+	if err := s.checkLimits(ctx, customerID, *item); err != nil {
+		return err
+	}
+	if err := s.price(ctx, item); err != nil {
+		return err
+	}
+	return s.db.CreateItem(ctx, customerID, item)
+}
+
+func (s *service) AddItems(ctx context.Context, customerID string, items []Item) (*Cart, error) {
+	if err := s.checkUnlocked(ctx, customerID); err != nil {
+		return nil, err
+	}
+	items, err := s.priceAll(ctx, items)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkLimits(ctx, customerID, items...); err != nil {
+		return nil, err
+	}
+	if err := s.db.CreateItems(ctx, customerID, items); err != nil {
+		return nil, err
+	}
+	return s.db.GetCart(ctx, customerID)
+}
+
+func (s *service) ReplaceItems(ctx context.Context, customerID string, items []Item) (*Cart, error) {
+	if err := s.checkUnlocked(ctx, customerID); err != nil {
+		return nil, err
+	}
+	items, err := s.priceAll(ctx, items)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.limits.check(items); err != nil {
+		return nil, err
+	}
+	if err := s.db.ReplaceItems(ctx, customerID, items); err != nil {
+		return nil, err
+	}
+	return s.db.GetCart(ctx, customerID)
+}
+
+// priceAll coalesces the items into one line per product and variant and
+// prices each line, failing the whole batch if any product is rejected.
+func (s *service) priceAll(ctx context.Context, items []Item) ([]Item, error) {
+	lines := make([]Item, 0, len(items))
+	index := map[line]int{}
+	for _, item := range items {
+		if item.Quantity == 0 {
+			item.Quantity = 1
+		}
+		if i, ok := index[item.line()]; ok {
+			lines[i].Quantity += item.Quantity
+			continue
+		}
+		index[item.line()] = len(lines)
+		lines = append(lines, Item{ItemID: item.ItemID, SKU: item.SKU, Quantity: item.Quantity})
+	}
+	for i := range lines {
+		if err := s.price(ctx, &lines[i]); err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
 }
 
 func (s *service) DeleteItem(ctx context.Context, customerID string, itemID string) error {
-	return db.DeleteItem(ctx, customerID, itemID)
+	if err := s.checkUnlocked(ctx, customerID); err != nil {
+		return err
+	}
+	return s.db.DeleteItem(ctx, customerID, itemID)
 }
 
 func (s *service) UpdateItem(ctx context.Context, customerID string, item *Item) error {
-	return db.UpdateItem(ctx, customerID, item)
+	if err := s.checkUnlocked(ctx, customerID); err != nil {
+		return err
+	}
+	current, err := s.db.GetItem(ctx, customerID, item.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.limits.check([]Item{*item}); err != nil {
+		return err
+	}
+	// an update changes the quantity of a line, never its product or variant
+	item.ItemID = current.ItemID
+	item.SKU = current.SKU
+	if err := s.price(ctx, item); err != nil {
+		return err
+	}
+	return s.db.UpdateItem(ctx, customerID, item)
+}
+
+func (s *service) GetList(ctx context.Context, customerID string, name string) (*List, error) {
+	if err := validList(name); err != nil {
+		return nil, err
+	}
+	return s.db.GetList(ctx, customerID, name)
+}
+
+func (s *service) AddListItem(ctx context.Context, customerID string, name string, item *Item) error {
+	if err := validList(name); err != nil {
+		return err
+	}
+	if item.Quantity == 0 {
+		item.Quantity = 1
+	}
+	// a list may keep products that are out of stock, so only check they exist
+	product, err := s.catalogue.GetProduct(ctx, item.ItemID)
+	if err != nil {
+		return err
+	}
+	variant, err := product.Variant(item.SKU)
+	if err != nil {
+		return err
+	}
+	item.UnitPrice = variant.Price
+	return s.db.AddListItem(ctx, customerID, name, item)
+}
+
+func (s *service) DeleteListItem(ctx context.Context, customerID string, name string, itemID string) error {
+	if err := validList(name); err != nil {
+		return err
+	}
+	return s.db.DeleteListItem(ctx, customerID, name, itemID)
+}
+
+func (s *service) MoveToList(ctx context.Context, customerID string, name string, itemID string) error {
+	if err := validList(name); err != nil {
+		return err
+	}
+	if err := s.checkUnlocked(ctx, customerID); err != nil {
+		return err
+	}
+	return s.db.MoveToList(ctx, customerID, name, itemID)
+}
+
+func (s *service) MoveToCart(ctx context.Context, customerID string, name string, itemID string) (*Item, error) {
+	if err := validList(name); err != nil {
+		return nil, err
+	}
+	if err := s.checkUnlocked(ctx, customerID); err != nil {
+		return nil, err
+	}
+	list, err := s.db.GetList(ctx, customerID, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, listItem := range list.Items {
+		if listItem.ID != itemID {
+			continue
+		}
+		item := &Item{
+			ID:       listItem.ID,
+			ItemID:   listItem.ItemID,
+			SKU:      listItem.SKU,
+			Quantity: listItem.Quantity,
+		}
+		if err := s.checkLimits(ctx, customerID, *item); err != nil {
+			return nil, err
+		}
+		if err := s.price(ctx, item); err != nil {
+			return nil, err
+		}
+		if err := s.db.MoveToCart(ctx, customerID, name, item); err != nil {
+			return nil, err
+		}
+		return item, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Checkout takes a snapshot of the cart, repriced from the catalogue, and
+// locks the cart until the snapshot is consumed, released or expires.
+func (s *service) Checkout(ctx context.Context, customerID string) (*Snapshot, error) {
+	cart, err := s.db.GetCart(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if cart.LockedUntil.After(time.Now()) {
+		return nil, ErrCartLocked
+	}
+	if len(cart.Items) == 0 {
+		return nil, ErrEmptyCart
+	}
+
+	items := make([]Item, len(cart.Items))
+	copy(items, cart.Items)
+	for i := range items {
+		if err := s.price(ctx, &items[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var promotion *Promotion
+	if cart.Coupon != "" {
+		promotion, err = s.promotion(ctx, customerID, cart.Coupon)
+		if err != nil && !isPromotionError(err) {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	snapshot := &Snapshot{
+		CustomerID:    customerID,
+		Items:         items,
+		Summary:       s.pricing.Summarise(items, promotion),
+		Status:        SnapshotOpen,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(s.checkoutTTL),
+		CartUpdatedAt: cart.UpdatedAt,
+	}
+	if err := s.db.CreateSnapshot(ctx, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (s *service) GetCheckout(ctx context.Context, customerID string, snapshotID string) (*Snapshot, error) {
+	snapshot, err := s.db.GetSnapshot(ctx, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.CustomerID != customerID {
+		return nil, ErrNotFound
+	}
+	if snapshot.expired(time.Now()) {
+		snapshot.Status = SnapshotExpired
+	}
+	return snapshot, nil
+}
+
+// ConsumeCheckout closes the snapshot once the order placed from it has been
+// paid, redeeming its coupon and emptying the cart.
+func (s *service) ConsumeCheckout(ctx context.Context, customerID string, snapshotID string) error {
+	snapshot, err := s.GetCheckout(ctx, customerID, snapshotID)
+	if err != nil {
+		return err
+	}
+	switch snapshot.Status {
+	case SnapshotOpen:
+	case SnapshotExpired:
+		return ErrSnapshotExpired
+	default:
+		return ErrSnapshotClosed
+	}
+	// a coupon used up meanwhile leaves the snapshot open to be released,
+	// and of two consumers of the snapshot only one redeems its coupon
+	return s.db.ConsumeSnapshot(ctx, snapshotID, s.pricing.Promotions[snapshot.Summary.Coupon].UsageLimit)
+}
+
+// ReleaseCheckout closes the snapshot without an order, unlocking the cart.
+func (s *service) ReleaseCheckout(ctx context.Context, customerID string, snapshotID string) error {
+	snapshot, err := s.GetCheckout(ctx, customerID, snapshotID)
+	if err != nil {
+		return err
+	}
+	if snapshot.Status != SnapshotOpen && snapshot.Status != SnapshotExpired {
+		return ErrSnapshotClosed
+	}
+	return s.db.CloseSnapshot(ctx, snapshotID, SnapshotReleased)
 }
 
 func (s *service) Ping(ctx context.Context) []HealthCheck {
 	now := time.Now()
 	app := HealthCheck{
-		Service: "orders",
+		Service: "cart",
 		Status:  "OK",
 		Date:    now,
 	}
 
 	database := HealthCheck{
-		Service: "orders-db",
+		Service: "cart-db",
 		Status:  "OK",
 		Date:    now,
 	}
 
-	if err := db.Ping(ctx); err != nil {
+	if err := s.db.Ping(ctx); err != nil {
 		database.Status = "err"
 	}
 
@@ -0,0 +1,95 @@
+package cart
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+var socks = testCatalogue{
+	"classic": {ID: "classic", Price: 12, Variants: []Variant{
+		{SKU: "classic-s", Price: 12, Count: 10},
+		{SKU: "classic-m", Price: 2, Count: 10},
+	}},
+}
+
+func TestUpdateItemKeepsVariant(t *testing.T) {
+	tests := []struct {
+		name string
+		sku  string
+	}{
+		{name: "same sku", sku: "classic-s"},
+		{name: "cheaper sku", sku: "classic-m"},
+		{name: "no sku", sku: ""},
+		{name: "unknown sku", sku: "classic-xxl"},
+	}
+	ctx := context.Background()
+	for backend, open := range testDatabases(t) {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				s := NewService(open(t), socks, DefaultPricingPolicy, DefaultLimits, time.Minute, zap.NewNop())
+
+				created := &Item{ItemID: "classic", SKU: "classic-s", Quantity: 1}
+				if err := s.CreateItem(ctx, "customer", created); err != nil {
+					t.Fatalf("create item: %v", err)
+				}
+				update := &Item{ID: created.ID, SKU: tt.sku, Quantity: 3, UnitPrice: 0.5}
+				if err := s.UpdateItem(ctx, "customer", update); err != nil {
+					t.Fatalf("update item: %v", err)
+				}
+
+				items, err := s.GetItems(ctx, "customer")
+				if err != nil {
+					t.Fatalf("get items: %v", err)
+				}
+				if len(*items) != 1 {
+					t.Fatalf("cart has %d lines, want 1", len(*items))
+				}
+				item := (*items)[0]
+				if item.SKU != "classic-s" || item.UnitPrice != 12 || item.Quantity != 3 {
+					t.Errorf("line = %s at %v x %d, want classic-s at 12 x 3", item.SKU, item.UnitPrice, item.Quantity)
+				}
+			})
+		}
+	}
+}
+
+func TestMargeCartFromRegisteredCustomer(t *testing.T) {
+	ctx := context.Background()
+	for backend, open := range testDatabases(t) {
+		t.Run(backend, func(t *testing.T) {
+			s := NewService(open(t), socks, DefaultPricingPolicy, DefaultLimits, time.Minute, zap.NewNop())
+			customer, session, other := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+
+			// the customer is registered once they log in
+			if err := s.CreateItem(ctx, customer, &Item{ItemID: "classic", SKU: "classic-s", Quantity: 2}); err != nil {
+				t.Fatalf("create item: %v", err)
+			}
+			if err := s.MargeCart(ctx, customer, session); err != nil {
+				t.Fatalf("merge the session: %v", err)
+			}
+
+			if err := s.MargeCart(ctx, other, customer); !errors.Is(err, ErrRegisteredSession) {
+				t.Fatalf("merge the customer: %v, want %v", err, ErrRegisteredSession)
+			}
+			items, err := s.GetItems(ctx, customer)
+			if err != nil {
+				t.Fatalf("get items: %v", err)
+			}
+			if len(*items) != 1 || (*items)[0].Quantity != 2 {
+				t.Errorf("customer's cart = %+v, want the line they added", *items)
+			}
+			items, err = s.GetItems(ctx, other)
+			if err != nil {
+				t.Fatalf("get items: %v", err)
+			}
+			if len(*items) != 0 {
+				t.Errorf("other cart = %+v, want it empty", *items)
+			}
+		})
+	}
+}
@@ -2,26 +2,103 @@ package cart
 
 import (
 	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func MakeHTTPHandler(service Service) *fiber.App {
-	app := fiber.New()
-	carts := app.Group("/carts/:customerID")
+	app := fiber.New(fiber.Config{
+		ErrorHandler: errorHandler,
+	})
+	carts := app.Group("/carts/:customerID", validateCustomer)
 	carts.Get("/", getCart(service))
-	carts.Delete("/", getCart(service))
+	carts.Delete("/", deleteCart(service))
 	carts.Get("/merge", mergeCart(service))
+	carts.Get("/summary", getSummary(service))
+	carts.Put("/promotion", applyPromotion(service))
+	carts.Delete("/promotion", removePromotion(service))
+	carts.Post("/promotion/redeem", redeemPromotion(service))
 	items := carts.Group("/items")
 	items.Get("/:itemID", getItem(service))
 	items.Get("/", getItems(service))
 	items.Post("/", createItem(service))
+	items.Post("/batch", addItems(service))
+	items.Put("/", replaceItems(service))
 	items.Delete("/:itemID", deleteItem(service))
 	items.Patch("/", updateItem(service))
+	items.Post("/:itemID/lists/:name", moveToList(service))
+	lists := carts.Group("/lists/:name")
+	lists.Get("/", getList(service))
+	lists.Post("/items", addListItem(service))
+	lists.Delete("/items/:itemID", deleteListItem(service))
+	lists.Post("/items/:itemID/cart", moveToCart(service))
+	carts.Post("/checkout", checkout(service))
+	checkouts := carts.Group("/checkout/:snapshotID", validateSnapshot)
+	checkouts.Get("/", getCheckout(service))
+	checkouts.Post("/consume", consumeCheckout(service))
+	checkouts.Delete("/", releaseCheckout(service))
 	app.Get("/health", health(service))
 	return app
 }
 
+func validateCustomer(c *fiber.Ctx) error {
+	if err := validateID("customerID", c.Params("customerID")); err != nil {
+		return err
+	}
+	return c.Next()
+}
+
+func validateSnapshot(c *fiber.Ctx) error {
+	if err := validateID("snapshotID", c.Params("snapshotID")); err != nil {
+		return err
+	}
+	return c.Next()
+}
+
+func validateID(name string, id string) error {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return invalidID(name, id)
+	}
+	return nil
+}
+
+// validateItem checks an item sent to be added to the cart or a list. A
+// missing quantity defaults to one.
+func validateItem(item Item) error {
+	if item.ItemID == "" {
+		return invalidBody("itemID is required")
+	}
+	if item.Quantity < 0 {
+		return invalidQuantity(item.Quantity)
+	}
+	return nil
+}
+
+func decodeItem(c *fiber.Ctx) (*Item, error) {
+	item := new(Item)
+	if err := json.Unmarshal(c.Body(), item); err != nil {
+		return nil, invalidBody(err.Error())
+	}
+	return item, nil
+}
+
+func decodeItems(c *fiber.Ctx) ([]Item, error) {
+	items := []Item{}
+	if err := json.Unmarshal(c.Body(), &items); err != nil {
+		return nil, invalidBody(err.Error())
+	}
+	for _, item := range items {
+		if err := validateItem(item); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
 func getCart(service Service) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		ctx := c.Context()
@@ -34,6 +111,55 @@ func getCart(service Service) func(c *fiber.Ctx) error {
 	}
 }
 
+func getSummary(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		summary, err := service.GetSummary(ctx, customerID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(summary)
+	}
+}
+
+func applyPromotion(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		request := struct {
+			Code string `json:"code"`
+		}{}
+		if err := json.Unmarshal(c.Body(), &request); err != nil {
+			return invalidBody(err.Error())
+		}
+		if request.Code == "" {
+			return invalidBody("code is required")
+		}
+		summary, err := service.ApplyPromotion(ctx, customerID, request.Code)
+		if err != nil {
+			return err
+		}
+		return c.JSON(summary)
+	}
+}
+
+func removePromotion(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		return service.RemovePromotion(ctx, customerID)
+	}
+}
+
+func redeemPromotion(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		return service.RedeemPromotion(ctx, customerID)
+	}
+}
+
 func deleteCart(service Service) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		ctx := c.Context()
@@ -47,6 +173,9 @@ func mergeCart(service Service) func(c *fiber.Ctx) error {
 		ctx := c.Context()
 		customerID := c.Params("customerID")
 		sessionID := c.Query("sessionId")
+		if err := validateID("sessionId", sessionID); err != nil {
+			return err
+		}
 		return service.MargeCart(ctx, customerID, sessionID)
 	}
 }
@@ -56,10 +185,14 @@ func getItem(service Service) func(c *fiber.Ctx) error {
 		ctx := c.Context()
 		customerID := c.Params("customerID")
 		itemID := c.Params("itemID")
+		if err := validateID("itemID", itemID); err != nil {
+			return err
+		}
 		item, err := service.GetItem(ctx, customerID, itemID)
 		if err != nil {
 			return err
 		}
+		c.Set(fiber.HeaderETag, etag(item.Version))
 		return c.JSON(item)
 	}
 }
@@ -80,11 +213,49 @@ func createItem(service Service) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		ctx := c.Context()
 		customerID := c.Params("customerID")
-		requestItem := new(Item)
-		if err := json.Unmarshal(c.Body(), requestItem); err != nil {
+		requestItem, err := decodeItem(c)
+		if err != nil {
+			return err
+		}
+		if err := validateItem(*requestItem); err != nil {
+			return err
+		}
+		if err := service.CreateItem(ctx, customerID, requestItem); err != nil {
 			return err
 		}
-		return service.CreateItem(ctx, customerID, requestItem)
+		return c.Status(fiber.StatusCreated).JSON(requestItem)
+	}
+}
+
+func addItems(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		requestItems, err := decodeItems(c)
+		if err != nil {
+			return err
+		}
+		cart, err := service.AddItems(ctx, customerID, requestItems)
+		if err != nil {
+			return err
+		}
+		return c.JSON(cart)
+	}
+}
+
+func replaceItems(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		requestItems, err := decodeItems(c)
+		if err != nil {
+			return err
+		}
+		cart, err := service.ReplaceItems(ctx, customerID, requestItems)
+		if err != nil {
+			return err
+		}
+		return c.JSON(cart)
 	}
 }
 
@@ -93,6 +264,9 @@ func deleteItem(service Service) func(c *fiber.Ctx) error {
 		ctx := c.Context()
 		customerID := c.Params("customerID")
 		itemID := c.Params("itemID")
+		if err := validateID("itemID", itemID); err != nil {
+			return err
+		}
 		return service.DeleteItem(ctx, customerID, itemID)
 	}
 }
@@ -101,11 +275,158 @@ func updateItem(service Service) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		ctx := c.Context()
 		customerID := c.Params("customerID")
-		requestItem := new(Item)
-		if err := json.Unmarshal(c.Body(), requestItem); err != nil {
+		requestItem, err := decodeItem(c)
+		if err != nil {
 			return err
 		}
-		return service.UpdateItem(ctx, customerID, requestItem)
+		if err := validateID("id", requestItem.ID); err != nil {
+			return err
+		}
+		if requestItem.Quantity <= 0 {
+			return invalidQuantity(requestItem.Quantity)
+		}
+		version, ok, err := ifMatch(c)
+		if err != nil {
+			return &Error{
+				Status:  fiber.StatusBadRequest,
+				Code:    "invalid_if_match",
+				Message: err.Error(),
+			}
+		}
+		if ok {
+			requestItem.Version = version
+		}
+		if err := service.UpdateItem(ctx, customerID, requestItem); err != nil {
+			// a stale If-Match is a failed precondition, a stale body version a conflict
+			if ok && errors.Is(err, ErrVersionConflict) {
+				return &Error{
+					Status:  fiber.StatusPreconditionFailed,
+					Code:    "precondition_failed",
+					Message: err.Error(),
+				}
+			}
+			return err
+		}
+		c.Set(fiber.HeaderETag, etag(requestItem.Version))
+		return c.JSON(requestItem)
+	}
+}
+
+func getList(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		name := c.Params("name")
+		list, err := service.GetList(ctx, customerID, name)
+		if err != nil {
+			return err
+		}
+		return c.JSON(list)
+	}
+}
+
+func addListItem(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		name := c.Params("name")
+		requestItem, err := decodeItem(c)
+		if err != nil {
+			return err
+		}
+		if err := validateItem(*requestItem); err != nil {
+			return err
+		}
+		if err := service.AddListItem(ctx, customerID, name, requestItem); err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusCreated).JSON(requestItem)
+	}
+}
+
+func deleteListItem(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		name := c.Params("name")
+		itemID := c.Params("itemID")
+		if err := validateID("itemID", itemID); err != nil {
+			return err
+		}
+		return service.DeleteListItem(ctx, customerID, name, itemID)
+	}
+}
+
+func moveToList(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		itemID := c.Params("itemID")
+		name := c.Params("name")
+		if err := validateID("itemID", itemID); err != nil {
+			return err
+		}
+		return service.MoveToList(ctx, customerID, name, itemID)
+	}
+}
+
+func moveToCart(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		name := c.Params("name")
+		itemID := c.Params("itemID")
+		if err := validateID("itemID", itemID); err != nil {
+			return err
+		}
+		item, err := service.MoveToCart(ctx, customerID, name, itemID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(item)
+	}
+}
+
+func checkout(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		snapshot, err := service.Checkout(ctx, customerID)
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusCreated).JSON(snapshot)
+	}
+}
+
+func getCheckout(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		snapshotID := c.Params("snapshotID")
+		snapshot, err := service.GetCheckout(ctx, customerID, snapshotID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(snapshot)
+	}
+}
+
+func consumeCheckout(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		snapshotID := c.Params("snapshotID")
+		return service.ConsumeCheckout(ctx, customerID, snapshotID)
+	}
+}
+
+func releaseCheckout(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		customerID := c.Params("customerID")
+		snapshotID := c.Params("snapshotID")
+		return service.ReleaseCheckout(ctx, customerID, snapshotID)
 	}
 }
 
@@ -121,3 +442,24 @@ func health(service Service) func(c *fiber.Ctx) error {
 		return c.JSON(response)
 	}
 }
+
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// ifMatch parses the item version from the If-Match header. A wildcard
+// matches any version and is reported as version 0.
+func ifMatch(c *fiber.Ctx) (int, bool, error) {
+	header := c.Get(fiber.HeaderIfMatch)
+	if header == "" {
+		return 0, false, nil
+	}
+	if header == "*" {
+		return 0, true, nil
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(header, "W/"), `"`))
+	if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
+}
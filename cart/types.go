@@ -3,16 +3,61 @@ package cart
 import "time"
 
 type Item struct {
+	ID        string  `json:"id" bson:"id"`
+	CartID    string  `json:"cartID" bson:"cartID"`
+	ItemID    string  `json:"itemID" bson:"itemID"`
+	SKU       string  `json:"sku,omitempty" bson:"sku"`
+	Quantity  int     `json:"quantity" bson:"quantity"`
+	UnitPrice float64 `json:"unitPrice" bson:"unitPrice"`
+	Version   int     `json:"version" bson:"version"`
+}
+
+// line identifies the product and variant of an item, of which a cart or a
+// list has one line at most.
+type line struct {
+	itemID string
+	sku    string
+}
+
+func (i Item) line() line {
+	return line{i.ItemID, i.SKU}
+}
+
+type Cart struct {
+	ID          string    `json:"id"`
+	CustomerID  string    `json:"customerID"`
+	Items       []Item    `json:"items"`
+	Coupon      string    `json:"coupon,omitempty"`
+	Checkout    string    `json:"checkout,omitempty"`
+	LockedUntil time.Time `json:"lockedUntil"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+type List struct {
+	Name       string    `json:"name"`
+	CustomerID string    `json:"customerID"`
+	Items      []Item    `json:"items"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+type LineTotal struct {
 	ID        string  `json:"id"`
-	CartID    string  `json:"cartID"`
+	ItemID    string  `json:"itemID"`
+	SKU       string  `json:"sku,omitempty"`
 	Quantity  int     `json:"quantity"`
 	UnitPrice float64 `json:"unitPrice"`
+	Total     float64 `json:"total"`
 }
 
-type Cart struct {
-	ID         string `json:"id"`
-	CustomerID string `json:"customerID"`
-	Items      []Item `json:"items"`
+type Summary struct {
+	Lines    []LineTotal `json:"lines"`
+	Subtotal float64     `json:"subtotal"`
+	Coupon   string      `json:"coupon,omitempty"`
+	Discount float64     `json:"discount"`
+	Shipping float64     `json:"shipping"`
+	Tax      float64     `json:"tax"`
+	Total    float64     `json:"total"`
 }
 
 type HealthCheck struct {
@@ -0,0 +1,77 @@
+package catalogue
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrInvalidSock = errors.New("invalid sock")
+	ErrInvalidTag  = errors.New("invalid tag")
+	ErrInvalidSlot = errors.New("image slot must be 1 or 2")
+)
+
+// sockID matches the ids a sock may be given: UUIDs like those of the seeded
+// socks, or slugs. Ids end up in the file names of uploaded images, so
+// nothing that could step out of the image directory is let through.
+var sockID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,39}$`)
+
+// validateSock checks a sock sent to the admin API before it is written. An
+// empty id is left to be generated.
+func validateSock(sock Sock) error {
+	switch {
+	case sock.ID != "" && !sockID.MatchString(sock.ID):
+		return fmt.Errorf("%w: id %q must be letters, digits, '-' or '_'", ErrInvalidSock, sock.ID)
+	case strings.TrimSpace(sock.Name) == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidSock)
+	case sock.Price < 0:
+		return fmt.Errorf("%w: price must not be negative", ErrInvalidSock)
+	case sock.Count < 0:
+		return fmt.Errorf("%w: count must not be negative", ErrInvalidSock)
+	case len(sock.ImageURL) > 2:
+		return fmt.Errorf("%w: at most 2 images", ErrInvalidSock)
+	}
+	for _, t := range sock.Tags {
+		if err := validateTag(t); err != nil {
+			return err
+		}
+	}
+	return validateVariants(sock.Variants)
+}
+
+func validateTag(name string) error {
+	if name == "" || strings.TrimSpace(name) != name || strings.Contains(name, ",") {
+		return fmt.Errorf("%w %q", ErrInvalidTag, name)
+	}
+	return nil
+}
+
+// newUUID generates a random UUID, the form of the ids of the seeded socks.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// RequireToken admits requests bearing the token, the admin token or the
+// token of the services allowed to hold stock.
+func RequireToken(token string) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		bearer := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			return fiber.ErrUnauthorized
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,337 @@
+package catalogue
+
+import (
+	lru "container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// CacheConfig bounds the cache and sets how long the results of each read
+// are kept. A zero TTL leaves that method uncached.
+type CacheConfig struct {
+	Size      int
+	ListTTL   time.Duration
+	CountTTL  time.Duration
+	GetTTL    time.Duration
+	TagsTTL   time.Duration
+	SearchTTL time.Duration
+}
+
+var DefaultCacheConfig = CacheConfig{
+	Size:      1000,
+	ListTTL:   time.Minute,
+	CountTTL:  time.Minute,
+	GetTTL:    5 * time.Minute,
+	TagsTTL:   10 * time.Minute,
+	SearchTTL: time.Minute,
+}
+
+// CacheStats counts the lookups of each method that were served from the
+// cache and that went through to the service.
+type CacheStats struct {
+	Hits          map[string]uint64 `json:"hits"`
+	Misses        map[string]uint64 `json:"misses"`
+	Evictions     uint64            `json:"evictions"`
+	Invalidations uint64            `json:"invalidations"`
+	Entries       int               `json:"entries"`
+}
+
+// Cache is a bounded LRU of the results of catalogue reads. Writes through
+// the service clear it, as nearly every read depends on every sock. Stock
+// moving in and out of reservations, which happens on every order, clears
+// only the reads it can change.
+type Cache struct {
+	mu         sync.Mutex
+	config     CacheConfig
+	entries    map[string]*lru.Element
+	order      *lru.List
+	generation uint64
+	stats      CacheStats
+}
+
+type cacheEntry struct {
+	method  string
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func NewCache(config CacheConfig) *Cache {
+	return &Cache{
+		config:  config,
+		entries: map[string]*lru.Element{},
+		order:   lru.New(),
+		stats: CacheStats{
+			Hits:   map[string]uint64{},
+			Misses: map[string]uint64{},
+		},
+	}
+}
+
+// Middleware caches the reads of the service in c.
+func (c *Cache) Middleware() Middleware {
+	return func(next Service) Service {
+		return cachingMiddleware{
+			next:  next,
+			cache: c,
+		}
+	}
+}
+
+// Stats returns a copy of the counters of the cache.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Hits = map[string]uint64{}
+	stats.Misses = map[string]uint64{}
+	for method, n := range c.stats.Hits {
+		stats.Hits[method] = n
+	}
+	for method, n := range c.stats.Misses {
+		stats.Misses[method] = n
+	}
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+// get looks the key up, returning along with it the generation a value
+// loaded on a miss must be stored under.
+func (c *Cache) get(method string, key string) (interface{}, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(e)
+			c.stats.Hits[method]++
+			return entry.value, c.generation, true
+		}
+		c.remove(e)
+	}
+	c.stats.Misses[method]++
+	return nil, c.generation, false
+}
+
+// put stores the value unless the cache was invalidated since generation,
+// in which case the value may predate the write that invalidated it.
+func (c *Cache) put(method string, key string, value interface{}, ttl time.Duration, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation || c.config.Size <= 0 {
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{method: method, key: key, value: value, expires: time.Now().Add(ttl)})
+	for c.order.Len() > c.config.Size {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) remove(e *lru.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).key)
+}
+
+// Invalidate drops every cached result.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]*lru.Element{}
+	c.order.Init()
+	c.generation++
+	c.stats.Invalidations++
+}
+
+// InvalidateSocks drops the cached socks with the given ids and every
+// cached listing, count and search, which may include them. The tag list
+// is kept.
+func (c *Cache) InvalidateSocks(ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	gets := map[string]bool{}
+	for _, id := range ids {
+		gets[cacheKey("Get", id)] = true
+	}
+	for e := c.order.Front(); e != nil; {
+		next := e.Next()
+		entry := e.Value.(*cacheEntry)
+		switch entry.method {
+		case "List", "Count", "Search":
+			c.remove(e)
+		case "Get":
+			if gets[entry.key] {
+				c.remove(e)
+			}
+		}
+		e = next
+	}
+	// a read in flight may have started before the write, so it is not
+	// stored whichever entry it is for
+	c.generation++
+	c.stats.Invalidations++
+}
+
+func cacheKey(method string, args ...interface{}) string {
+	b, _ := json.Marshal(args)
+	return method + string(b)
+}
+
+// cached returns the cached result of the method for key, or loads, caches
+// and returns it. Errors are not cached.
+func (c *Cache) cached(method string, ttl time.Duration, key string, load func() (interface{}, error)) (interface{}, error) {
+	if ttl <= 0 {
+		return load()
+	}
+	value, generation, ok := c.get(method, key)
+	if ok {
+		return value, nil
+	}
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+	c.put(method, key, value, ttl, generation)
+	return value, nil
+}
+
+type cachingMiddleware struct {
+	next  Service
+	cache *Cache
+}
+
+func (mw cachingMiddleware) List(filter Filter, page PageRequest) (Page, error) {
+	v, err := mw.cache.cached("List", mw.cache.config.ListTTL, cacheKey("List", filter, page), func() (interface{}, error) {
+		return mw.next.List(filter, page)
+	})
+	return v.(Page), err
+}
+
+func (mw cachingMiddleware) Count(filter Filter) (int, error) {
+	v, err := mw.cache.cached("Count", mw.cache.config.CountTTL, cacheKey("Count", filter), func() (interface{}, error) {
+		return mw.next.Count(filter)
+	})
+	return v.(int), err
+}
+
+func (mw cachingMiddleware) Get(id string) (Sock, error) {
+	v, err := mw.cache.cached("Get", mw.cache.config.GetTTL, cacheKey("Get", id), func() (interface{}, error) {
+		return mw.next.Get(id)
+	})
+	return v.(Sock), err
+}
+
+func (mw cachingMiddleware) Search(query SearchQuery) (SearchResult, error) {
+	v, err := mw.cache.cached("Search", mw.cache.config.SearchTTL, cacheKey("Search", query), func() (interface{}, error) {
+		return mw.next.Search(query)
+	})
+	return v.(SearchResult), err
+}
+
+func (mw cachingMiddleware) Tags() ([]string, error) {
+	v, err := mw.cache.cached("Tags", mw.cache.config.TagsTTL, cacheKey("Tags"), func() (interface{}, error) {
+		return mw.next.Tags()
+	})
+	return v.([]string), err
+}
+
+func (mw cachingMiddleware) CreateSock(sock Sock) (Sock, error) {
+	defer mw.cache.Invalidate()
+	return mw.next.CreateSock(sock)
+}
+
+func (mw cachingMiddleware) UpdateSock(sock Sock) (Sock, error) {
+	defer mw.cache.Invalidate()
+	return mw.next.UpdateSock(sock)
+}
+
+func (mw cachingMiddleware) DeleteSock(id string) error {
+	defer mw.cache.Invalidate()
+	return mw.next.DeleteSock(id)
+}
+
+func (mw cachingMiddleware) CreateTag(name string) error {
+	defer mw.cache.Invalidate()
+	return mw.next.CreateTag(name)
+}
+
+func (mw cachingMiddleware) DeleteTag(name string) error {
+	defer mw.cache.Invalidate()
+	return mw.next.DeleteTag(name)
+}
+
+func (mw cachingMiddleware) TagSock(id string, tag string) (Sock, error) {
+	defer mw.cache.Invalidate()
+	return mw.next.TagSock(id, tag)
+}
+
+func (mw cachingMiddleware) UntagSock(id string, tag string) (Sock, error) {
+	defer mw.cache.Invalidate()
+	return mw.next.UntagSock(id, tag)
+}
+
+func (mw cachingMiddleware) SetImage(id string, slot int, url string) (Sock, error) {
+	defer mw.cache.Invalidate()
+	return mw.next.SetImage(id, slot, url)
+}
+
+// Reserving and releasing stock change the counts of the socks reserved,
+// committing a reservation changes how many were sold, which listings are
+// sorted by.
+
+func (mw cachingMiddleware) Reserve(items []ReservationItem, ttl time.Duration) (Reservation, error) {
+	r, err := mw.next.Reserve(items, ttl)
+	if err == nil {
+		mw.invalidateReserved(r)
+	}
+	return r, err
+}
+
+func (mw cachingMiddleware) GetReservation(id string) (Reservation, error) {
+	return mw.next.GetReservation(id)
+}
+
+func (mw cachingMiddleware) CommitReservation(id string) (Reservation, error) {
+	r, err := mw.next.CommitReservation(id)
+	if err == nil || r.Status == ReservationExpired {
+		mw.invalidateReserved(r)
+	}
+	return r, err
+}
+
+func (mw cachingMiddleware) ReleaseReservation(id string) (Reservation, error) {
+	r, err := mw.next.ReleaseReservation(id)
+	if err == nil {
+		mw.invalidateReserved(r)
+	}
+	return r, err
+}
+
+func (mw cachingMiddleware) invalidateReserved(r Reservation) {
+	ids := make([]string, 0, len(r.Items))
+	for _, item := range r.Items {
+		ids = append(ids, item.SockID)
+	}
+	mw.cache.InvalidateSocks(ids...)
+}
+
+func (mw cachingMiddleware) ReleaseExpired(now time.Time) (int, error) {
+	n, err := mw.next.ReleaseExpired(now)
+	if n > 0 {
+		mw.cache.Invalidate()
+	}
+	return n, err
+}
+
+func (mw cachingMiddleware) Health() []Health {
+	return mw.next.Health()
+}
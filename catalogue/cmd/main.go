@@ -1,17 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"catalogue"
 
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 )
 
@@ -19,11 +19,23 @@ const ServiceName = "catalogue"
 
 func main() {
 	var (
-		port   = flag.String("port", "80", "Port to bind HTTP listener") // TODO(pb): should be -addr, default ":80"
-		images = flag.String("images", "./images/", "Image path")
-		_      = flag.String("DSN", "catalogue_user:default_password@tcp(catalogue-db:3306)/socksdb", "Data Source Name: [username[:password]@][protocol[(address)]]/dbname")
-		_      = flag.String("zipkin", os.Getenv("ZIPKIN"), "Zipkin address")
+		port       = flag.String("port", "80", "Port to bind HTTP listener") // TODO(pb): should be -addr, default ":80"
+		images     = flag.String("images", "./images/", "Image path")
+		imageCache = flag.String("image-cache", filepath.Join(os.TempDir(), "catalogue-images"), "Directory resized images are cached in")
+		dsn        = flag.String("DSN", dsnDefault(), "Data Source Name: memory://, sqlite://path (built with cgo) or [mysql://][username[:password]@][protocol[(address)]]/dbname")
+		seed       = flag.Bool("seed", false, "Fill an empty catalogue with the seed socks")
+		_          = flag.String("zipkin", os.Getenv("ZIPKIN"), "Zipkin address")
+		reap       = flag.Duration("reap-interval", time.Minute, "Interval between releases of expired stock reservations")
+		admin      = flag.String("admin-token", os.Getenv("CATALOGUE_ADMIN_TOKEN"), "Bearer token of the admin API, disabled when empty")
+		inventory  = flag.String("inventory-token", os.Getenv("CATALOGUE_INVENTORY_TOKEN"), "Bearer token of the inventory API, disabled when empty")
 	)
+	cacheConfig := catalogue.DefaultCacheConfig
+	flag.IntVar(&cacheConfig.Size, "cache-size", cacheConfig.Size, "Maximum number of cached results, 0 to disable the cache")
+	flag.DurationVar(&cacheConfig.ListTTL, "cache-list-ttl", cacheConfig.ListTTL, "Time list results are cached for")
+	flag.DurationVar(&cacheConfig.CountTTL, "cache-count-ttl", cacheConfig.CountTTL, "Time count results are cached for")
+	flag.DurationVar(&cacheConfig.GetTTL, "cache-get-ttl", cacheConfig.GetTTL, "Time socks are cached for")
+	flag.DurationVar(&cacheConfig.TagsTTL, "cache-tags-ttl", cacheConfig.TagsTTL, "Time the tag list is cached for")
+	flag.DurationVar(&cacheConfig.SearchTTL, "cache-search-ttl", cacheConfig.SearchTTL, "Time search results are cached for")
 	flag.Parse()
 
 	fmt.Fprintf(os.Stderr, "images: %q\n", *images)
@@ -34,26 +46,60 @@ func main() {
 	files, _ := filepath.Glob(*images + "/*")
 	fmt.Fprintf(os.Stderr, "ls: %q\n", files)
 
-	// ctx := context.Background()
-
 	logger := zap.L()
 
 	// TODO opentelemetry
 
-	db, err := sqlx.Connect("mysql", "user:password@/dbname")
+	// held stock would never go back on sale without the reaper
+	if *reap <= 0 {
+		logger.Fatal("reap interval must be positive", zap.Duration("reap-interval", *reap))
+	}
+
+	repo, err := catalogue.OpenRepository(*dsn)
 	if err != nil {
 		logger.Fatal("Error", zap.Error(err))
 	}
-	defer db.Close()
+	defer repo.Close()
 
-	if err = db.Ping(); err != nil {
+	if err := repo.Ping(); err != nil {
 		logger.Error("Error", zap.Error(err))
 	}
 
-	service := catalogue.NewCatalogueService(db, logger)
+	if err := repo.Migrate(); err != nil {
+		logger.Fatal("migrate", zap.Error(err))
+	}
+
+	if *seed {
+		n, err := catalogue.Seed(repo)
+		if err != nil {
+			logger.Fatal("seed", zap.Error(err))
+		}
+		logger.Info("seed", zap.Int("socks", n))
+	}
+
+	index := catalogue.NewInvertedIndex()
+	if err := catalogue.RebuildIndex(repo, index); err != nil {
+		logger.Error("build search index", zap.Error(err))
+	}
+
+	service := catalogue.NewCatalogueService(repo, index, logger)
+	var cache *catalogue.Cache
+	if cacheConfig.Size > 0 {
+		cache = catalogue.NewCache(cacheConfig)
+		service = cache.Middleware()(service)
+	}
 	service = catalogue.LoggingMiddleware(logger)(service)
 
-	app := catalogue.MakeHTTPHandler(service, *images)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go catalogue.RunReaper(ctx, service, *reap, logger)
+
+	app := catalogue.MakeHTTPHandler(service, *images, *imageCache, *admin, *inventory)
+	// the cache stats are served to the admin alone, like the rest of the
+	// catalogue's internals
+	if cache != nil && *admin != "" {
+		app.Get("/cache/stats", catalogue.RequireToken(*admin), catalogue.CacheStatsHandler(cache))
+	}
 
 	errc := make(chan error)
 
@@ -70,3 +116,12 @@ func main() {
 
 	logger.Info("exit", zap.Error(<-errc))
 }
+
+// dsnDefault is the DSN of the catalogue-db container unless CATALOGUE_DSN
+// names another.
+func dsnDefault() string {
+	if dsn := os.Getenv("CATALOGUE_DSN"); dsn != "" {
+		return dsn
+	}
+	return "catalogue_user:default_password@tcp(catalogue-db:3306)/socksdb"
+}
@@ -1,6 +1,8 @@
 package catalogue
 
 import (
+	"encoding/json"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -13,33 +15,142 @@ func decodeListRequest(ctx *fiber.Ctx) (listRequest, error) {
 	if page := ctx.FormValue("page"); page != "" {
 		pageNum, _ = strconv.Atoi(page)
 	}
-	pageSize := 10
-	if size := ctx.FormValue("size"); size != "" {
-		pageSize, _ = strconv.Atoi(size)
-	}
+	pageSize := decodePageSize(ctx.FormValue("size"))
 	order := "id"
 	if sort := ctx.FormValue("sort"); sort != "" {
 		order = strings.ToLower(sort)
 	}
-	tags := []string{}
-	if tagsval := ctx.FormValue("tags"); tagsval != "" {
-		tags = strings.Split(tagsval, ",")
+	filter, err := decodeFilter(ctx)
+	if err != nil {
+		return listRequest{}, err
+	}
+	filter.Order = order
+	if err := filter.Validate(); err != nil {
+		return listRequest{}, err
 	}
 	return listRequest{
-		Tags:     tags,
-		Order:    order,
-		PageNum:  pageNum,
-		PageSize: pageSize,
+		Filter: filter,
+		Page: PageRequest{
+			Num:    pageNum,
+			Size:   pageSize,
+			Cursor: ctx.FormValue("cursor"),
+		},
 	}, nil
 }
 
 func decodeCountRequest(ctx *fiber.Ctx) (countRequest, error) {
 	_ = ctx.Context()
+	filter, err := decodeFilter(ctx)
+	if err != nil {
+		return countRequest{}, err
+	}
+	return countRequest{
+		Filter: filter,
+	}, nil
+}
+
+func decodeSearchRequest(ctx *fiber.Ctx) (searchRequest, error) {
+	text := strings.TrimSpace(ctx.FormValue("q"))
+	if text == "" {
+		return searchRequest{}, ErrEmptySearch
+	}
+	pageNum := 1
+	if page := ctx.FormValue("page"); page != "" {
+		pageNum, _ = strconv.Atoi(page)
+	}
+	pageSize := decodePageSize(ctx.FormValue("size"))
+	filter, err := decodeFilter(ctx)
+	if err != nil {
+		return searchRequest{}, err
+	}
+	return searchRequest{
+		Query: SearchQuery{
+			Text:  text,
+			Tags:  filter.Tags,
+			Match: filter.Match,
+			Page: PageRequest{
+				Num:  pageNum,
+				Size: pageSize,
+			},
+		},
+	}, nil
+}
+
+// decodePageSize reads the size of a page, 10 by default and at most
+// MaxPageSize.
+func decodePageSize(size string) int {
+	pageSize := 10
+	if size != "" {
+		pageSize, _ = strconv.Atoi(size)
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	return pageSize
+}
+
+func decodeSockRequest(ctx *fiber.Ctx) (sockRequest, error) {
+	req := sockRequest{}
+	if err := json.Unmarshal(ctx.Body(), &req); err != nil {
+		return sockRequest{}, err
+	}
+	return req, nil
+}
+
+// decodeFilter reads the tags to filter on and whether a sock must have any
+// or all of them, any by default, the attributes one of its variants must
+// have, as name:value pairs, the range of prices and whether only socks in
+// stock are wanted.
+func decodeFilter(ctx *fiber.Ctx) (Filter, error) {
 	tags := []string{}
 	if tagsval := ctx.FormValue("tags"); tagsval != "" {
 		tags = strings.Split(tagsval, ",")
 	}
-	return countRequest{
-		Tags: tags,
-	}, nil
+	match := MatchAny
+	if matchval := ctx.FormValue("match"); matchval != "" {
+		match = TagMatch(strings.ToLower(matchval))
+	}
+	minPrice, err := decodePrice(ctx.FormValue("min_price"))
+	if err != nil {
+		return Filter{}, err
+	}
+	maxPrice, err := decodePrice(ctx.FormValue("max_price"))
+	if err != nil {
+		return Filter{}, err
+	}
+	inStock := false
+	if instockval := ctx.FormValue("in_stock"); instockval != "" {
+		if inStock, err = strconv.ParseBool(instockval); err != nil {
+			return Filter{}, err
+		}
+	}
+	var attributes map[string]string
+	if attrsval := ctx.FormValue("attrs"); attrsval != "" {
+		if attributes, err = parseAttributes(attrsval); err != nil {
+			return Filter{}, err
+		}
+	}
+	filter := Filter{
+		Tags:       tags,
+		Match:      match,
+		Attributes: attributes,
+		MinPrice:   minPrice,
+		MaxPrice:   maxPrice,
+		InStock:    inStock,
+	}
+	if err := filter.Validate(); err != nil {
+		return Filter{}, err
+	}
+	return filter, nil
+}
+
+func decodePrice(s string) (float32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	price, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: price %q", ErrInvalidPrice, s)
+	}
+	return float32(price), nil
 }
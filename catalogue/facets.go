@@ -0,0 +1,80 @@
+package catalogue
+
+import (
+	"fmt"
+	"strings"
+)
+
+// priceBucketEdges split prices into the buckets of the price facet.
+var priceBucketEdges = []float32{10, 15, 20, 50}
+
+// PriceBucket counts the socks priced from Min up to but not including Max.
+// The last bucket has no Max.
+type PriceBucket struct {
+	Min   float32 `json:"min"`
+	Max   float32 `json:"max,omitempty"`
+	Count int     `json:"count"`
+}
+
+// Facets summarise the socks a filter selects, for a listing to offer ways
+// of narrowing it down.
+type Facets struct {
+	Tags   map[string]int `json:"tags"`
+	Prices []PriceBucket  `json:"price"`
+}
+
+// facetFilters returns the filters tags and prices are counted over. Each
+// leaves out its own part of the filter, so that the counts show what
+// choosing another tag or price would find, except for tags that must all
+// match, which can only narrow the selection further.
+func (f Filter) facetFilters() (tags Filter, prices Filter) {
+	tags, prices = f, f
+	if f.Match != MatchAll {
+		tags.Tags = nil
+	}
+	prices.MinPrice, prices.MaxPrice = 0, 0
+	return tags, prices
+}
+
+func newPriceBuckets() []PriceBucket {
+	buckets := make([]PriceBucket, len(priceBucketEdges)+1)
+	for i, edge := range priceBucketEdges {
+		buckets[i].Max = edge
+		buckets[i+1].Min = edge
+	}
+	return buckets
+}
+
+// priceBuckets returns the indexes of the buckets the prices of the sock,
+// its own and those of its variants, fall in.
+func priceBuckets(sock Sock) map[int]bool {
+	buckets := map[int]bool{priceBucket(sock.Price): true}
+	for _, v := range sock.Variants {
+		buckets[priceBucket(v.Price)] = true
+	}
+	return buckets
+}
+
+// priceBucket returns the index of the bucket the price falls in.
+func priceBucket(price float32) int {
+	for i, edge := range priceBucketEdges {
+		if price < edge {
+			return i
+		}
+	}
+	return len(priceBucketEdges)
+}
+
+// priceBucketCase is an SQL expression evaluating to the index of the bucket
+// the price in column falls in, along with the values bound to it.
+func priceBucketCase(column string) (string, []interface{}) {
+	var b strings.Builder
+	args := make([]interface{}, len(priceBucketEdges))
+	b.WriteString("CASE")
+	for i, edge := range priceBucketEdges {
+		fmt.Fprintf(&b, " WHEN %s < ? THEN %d", column, i)
+		args[i] = float64(edge)
+	}
+	fmt.Fprintf(&b, " ELSE %d END", len(priceBucketEdges))
+	return b.String(), args
+}
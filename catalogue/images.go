@@ -0,0 +1,239 @@
+package catalogue
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// maxImageWidth bounds the variants that may be requested, and so the
+	// disk the variant cache can take.
+	maxImageWidth = 1024
+	// maxImagePixels bounds the images that are decoded to be resized, and
+	// so the memory and time a request for a variant can take.
+	maxImagePixels = 6000 * 6000
+	imageMaxAge    = 24 * 60 * 60
+)
+
+var (
+	ErrInvalidWidth  = fmt.Errorf("width must be between 1 and %d", maxImageWidth)
+	ErrImageTooLarge = errors.New("image too large to resize")
+)
+
+// serveImage serves the images of socks, resized to the width query parameter
+// when it is given. Resized variants are kept in cacheDir until the image
+// they were made from changes.
+func serveImage(imagePath string, cacheDir string) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		name := c.Params("name")
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") || !imageExtensions[strings.ToLower(filepath.Ext(name))] {
+			return fiber.ErrNotFound
+		}
+		path := filepath.Join(imagePath, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+
+		width := 0
+		if w := c.Query("width"); w != "" {
+			width, err = strconv.Atoi(w)
+			if err != nil || width < 1 || width > maxImageWidth {
+				return fiber.NewError(fiber.StatusBadRequest, ErrInvalidWidth.Error())
+			}
+		}
+
+		etag := fmt.Sprintf(`"%x-%x-%d"`, info.ModTime().UnixNano(), info.Size(), width)
+		c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", imageMaxAge))
+		c.Set(fiber.HeaderETag, etag)
+		c.Set(fiber.HeaderLastModified, info.ModTime().UTC().Format(http.TimeFormat))
+		if c.Get(fiber.HeaderIfNoneMatch) == etag {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+
+		if width > 0 {
+			variant, err := resizedImage(path, info, cacheDir, width)
+			if errors.Is(err, ErrImageTooLarge) {
+				return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+			}
+			if err != nil {
+				return err
+			}
+			path = variant
+		}
+		c.Type(strings.TrimPrefix(filepath.Ext(name), "."))
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return c.Send(b)
+	}
+}
+
+// resizedImage returns the path of the variant of the image at path scaled
+// down to width, making it unless an up to date one is cached. Images
+// already no wider than width are cached as they are, so that they are not
+// decoded again.
+func resizedImage(path string, info os.FileInfo, cacheDir string, width int) (string, error) {
+	variant := filepath.Join(cacheDir, strconv.Itoa(width), filepath.Base(path))
+	// concurrent requests for a variant not cached yet make it once
+	defer resizing.lock(variant)()
+	if cached, err := os.Stat(variant); err == nil && !cached.ModTime().Before(info.ModTime()) {
+		return variant, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	config, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", err
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return "", fmt.Errorf("%w: %dx%d", ErrImageTooLarge, config.Width, config.Height)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	write := func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	}
+	if config.Width > width {
+		src, _, err := image.Decode(f)
+		if err != nil {
+			return "", err
+		}
+		dst := resize(src, width)
+		write = func(w io.Writer) error {
+			return encodeImage(w, dst, format)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(variant), 0755); err != nil {
+		return "", err
+	}
+	// write to a temporary file first so a concurrent request never serves
+	// a partly written variant
+	tmp, err := ioutil.TempFile(filepath.Dir(variant), ".resize-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	err = write(tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), variant); err != nil {
+		return "", err
+	}
+	return variant, nil
+}
+
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	}
+	return errors.New("unsupported image format " + format)
+}
+
+// keyedMutex holds a lock for each key in use.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	waiters int
+}
+
+var resizing = &keyedMutex{locks: map[string]*keyedLock{}}
+
+// lock locks the key, returning the function that unlocks it.
+func (m *keyedMutex) lock(key string) func() {
+	m.mu.Lock()
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.waiters++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.mu.Lock()
+		if l.waiters--; l.waiters == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// resize scales src down to width, keeping its aspect ratio, averaging the
+// source pixels each destination pixel covers.
+func resize(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,117 @@
+package catalogue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writePNG writes a blank PNG of the given size, returning its path and
+// info.
+func writePNG(t *testing.T, dir string, name string, width, height int) (string, os.FileInfo) {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, info
+}
+
+func TestResizedImage(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		want   int
+	}{
+		{name: "wider", width: 40, height: 20, want: 10},
+		{name: "as wide", width: 10, height: 20, want: 10},
+		{name: "narrower", width: 4, height: 20, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path, info := writePNG(t, dir, "sock.png", tt.width, tt.height)
+			cache := filepath.Join(dir, "cache")
+
+			// concurrent requests all get the one variant
+			var wg sync.WaitGroup
+			variants := make([]string, 8)
+			errs := make([]error, len(variants))
+			for i := range variants {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					variants[i], errs[i] = resizedImage(path, info, cache, 10)
+				}(i)
+			}
+			wg.Wait()
+			for i := range variants {
+				if errs[i] != nil {
+					t.Fatalf("resize: %v", errs[i])
+				}
+				if variants[i] != filepath.Join(cache, "10", "sock.png") {
+					t.Fatalf("variant = %s, want it cached", variants[i])
+				}
+			}
+
+			f, err := os.Open(variants[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			config, err := png.DecodeConfig(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != tt.want {
+				t.Errorf("width = %d, want %d", config.Width, tt.want)
+			}
+			if len(resizing.locks) != 0 {
+				t.Errorf("%d resize locks left", len(resizing.locks))
+			}
+		})
+	}
+}
+
+func TestResizedImageTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	path, _ := writePNG(t, dir, "sock.png", 1, 1)
+
+	// claim a size over the budget in the header without the pixels
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const ihdr = 8 + 4
+	binary.BigEndian.PutUint32(b[ihdr+4:], 100000)
+	binary.BigEndian.PutUint32(b[ihdr+8:], 100000)
+	binary.BigEndian.PutUint32(b[ihdr+4+13:], crc32.ChecksumIEEE(b[ihdr:ihdr+4+13]))
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := resizedImage(path, info, filepath.Join(dir, "cache"), 10); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("resize: %v, want %v", err, ErrImageTooLarge)
+	}
+}
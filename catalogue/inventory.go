@@ -0,0 +1,105 @@
+package catalogue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type ReservationStatus string
+
+const (
+	ReservationHeld      ReservationStatus = "held"
+	ReservationCommitted ReservationStatus = "committed"
+	ReservationReleased  ReservationStatus = "released"
+	ReservationExpired   ReservationStatus = "expired"
+)
+
+var (
+	ErrInsufficientStock  = errors.New("insufficient stock")
+	ErrInvalidReservation = errors.New("invalid reservation")
+	ErrReservationExpired = errors.New("reservation expired")
+	ErrReservationClosed  = errors.New("reservation already closed")
+)
+
+// ReservationItem is a quantity of a sock held by a reservation, taken from
+// the stock of the variant with the SKU if it is set and from the sock's own
+// stock otherwise.
+type ReservationItem struct {
+	SockID   string `json:"id" db:"sock_id"`
+	SKU      string `json:"sku,omitempty" db:"sku"`
+	Quantity int    `json:"quantity" db:"quantity"`
+}
+
+// Reservation holds stock for an order while it is paid for. Held stock is
+// taken off the count of the socks straight away, and given back if the
+// reservation is released or expires before it is committed.
+type Reservation struct {
+	ID        string            `json:"id" db:"reservation_id"`
+	Status    ReservationStatus `json:"status" db:"status"`
+	Items     []ReservationItem `json:"items" db:"-"`
+	CreatedAt time.Time         `json:"createdAt" db:"-"`
+	ExpiresAt time.Time         `json:"expiresAt" db:"-"`
+	Created   int64             `json:"-" db:"created_at"`
+	Expires   int64             `json:"-" db:"expires_at"`
+}
+
+// mergeReservationItems coalesces the items into one per sock and variant,
+// ordered by sock id and SKU so that rows are always locked in the same
+// order.
+func mergeReservationItems(items []ReservationItem) ([]ReservationItem, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: no items", ErrInvalidReservation)
+	}
+	type stock struct {
+		sockID string
+		sku    string
+	}
+	quantities := map[stock]int{}
+	for _, item := range items {
+		if item.SockID == "" || item.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: item %q quantity %d", ErrInvalidReservation, item.SockID, item.Quantity)
+		}
+		quantities[stock{item.SockID, item.SKU}] += item.Quantity
+	}
+	merged := make([]ReservationItem, 0, len(quantities))
+	for s, quantity := range quantities {
+		merged = append(merged, ReservationItem{SockID: s.sockID, SKU: s.sku, Quantity: quantity})
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].SockID != merged[j].SockID {
+			return merged[i].SockID < merged[j].SockID
+		}
+		return merged[i].SKU < merged[j].SKU
+	})
+	return merged, nil
+}
+
+// RunReaper releases expired reservations every interval until ctx is done.
+func RunReaper(ctx context.Context, service Service, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	total := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			released, err := service.ReleaseExpired(now)
+			if err != nil {
+				logger.Error("release expired reservations", zap.Error(err))
+				continue
+			}
+			if released == 0 {
+				continue
+			}
+			total += released
+			logger.Info("release expired reservations", zap.Int("released", released), zap.Int("total", total))
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package catalogue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	weaveSpecial = "6d62d909-f957-430e-8689-b5129c0bb75e"
+	superSportXL = "510a0d7e-8e83-4193-b483-e27e09ddc34d"
+)
+
+// stock returns the count of the sock, or of its variant with the SKU, and
+// how many of the sock were sold.
+func stock(t *testing.T, repo Repository, id string, sku string) (int, int) {
+	t.Helper()
+	sock, err := repo.Get(id)
+	if err != nil {
+		t.Fatalf("get %s: %v", id, err)
+	}
+	if sku == "" {
+		return sock.Count, sock.Sold
+	}
+	for _, v := range sock.Variants {
+		if v.SKU == sku {
+			return v.Count, sock.Sold
+		}
+	}
+	t.Fatalf("sock %s has no variant %s", id, sku)
+	return 0, 0
+}
+
+func TestReservation(t *testing.T) {
+	items := []ReservationItem{
+		{SockID: superSportXL, SKU: "supersport-xl-m", Quantity: 3},
+		{SockID: superSportXL, SKU: "supersport-xl-xl", Quantity: 2},
+		{SockID: weaveSpecial, Quantity: 4},
+	}
+	tests := []struct {
+		name string
+		// close closes the reservation, as an order would
+		close     func(s Service, id string) (Reservation, error)
+		status    ReservationStatus
+		err       error
+		sold      int
+		remaining map[string]int
+	}{
+		{
+			name:      "commit",
+			close:     Service.CommitReservation,
+			status:    ReservationCommitted,
+			sold:      5,
+			remaining: map[string]int{"supersport-xl-m": 297, "supersport-xl-l": 320, "supersport-xl-xl": 198, "": 29},
+		},
+		{
+			name:      "release",
+			close:     Service.ReleaseReservation,
+			status:    ReservationReleased,
+			remaining: map[string]int{"supersport-xl-m": 300, "supersport-xl-l": 320, "supersport-xl-xl": 200, "": 33},
+		},
+	}
+	for backend, open := range testRepositories(t) {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				repo := open(t)
+				s := NewCatalogueService(repo, NewInvertedIndex(), zap.NewNop())
+
+				r, err := s.Reserve(items, time.Minute)
+				if err != nil {
+					t.Fatalf("reserve: %v", err)
+				}
+				if count, _ := stock(t, repo, superSportXL, "supersport-xl-m"); count != 297 {
+					t.Errorf("held count of supersport-xl-m = %d, want 297", count)
+				}
+				if count, _ := stock(t, repo, superSportXL, ""); count != 0 {
+					t.Errorf("own count of the sock with variants = %d, want 0", count)
+				}
+
+				closed, err := tt.close(s, r.ID)
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("close: %v, want %v", err, tt.err)
+				}
+				if closed.Status != tt.status {
+					t.Errorf("status = %s, want %s", closed.Status, tt.status)
+				}
+				for sku, want := range tt.remaining {
+					id := superSportXL
+					if sku == "" {
+						id = weaveSpecial
+					}
+					if count, _ := stock(t, repo, id, sku); count != want {
+						t.Errorf("count of %q = %d, want %d", sku, count, want)
+					}
+				}
+				if _, sold := stock(t, repo, superSportXL, ""); sold != tt.sold {
+					t.Errorf("sold = %d, want %d", sold, tt.sold)
+				}
+
+				// closing it again changes nothing
+				if _, err := s.CommitReservation(r.ID); !errors.Is(err, ErrReservationClosed) {
+					t.Errorf("commit again: %v, want %v", err, ErrReservationClosed)
+				}
+			})
+		}
+	}
+}
+
+func TestReserveRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []ReservationItem
+		err   error
+	}{
+		{
+			name:  "variant out of stock",
+			items: []ReservationItem{{SockID: weaveSpecial, Quantity: 1}, {SockID: superSportXL, SKU: "supersport-xl-xl", Quantity: 201}},
+			err:   ErrInsufficientStock,
+		},
+		{
+			name:  "sock out of stock",
+			items: []ReservationItem{{SockID: superSportXL, SKU: "supersport-xl-m", Quantity: 1}, {SockID: weaveSpecial, Quantity: 34}},
+			err:   ErrInsufficientStock,
+		},
+		{
+			name:  "stock of a sock sold in variants",
+			items: []ReservationItem{{SockID: superSportXL, Quantity: 1}},
+			err:   ErrInsufficientStock,
+		},
+		{
+			name:  "unknown sku",
+			items: []ReservationItem{{SockID: superSportXL, SKU: "supersport-xl-xxl", Quantity: 1}},
+			err:   ErrNotFound,
+		},
+		{
+			name:  "sku of another sock",
+			items: []ReservationItem{{SockID: weaveSpecial, SKU: "supersport-xl-m", Quantity: 1}},
+			err:   ErrNotFound,
+		},
+		{
+			name:  "no quantity",
+			items: []ReservationItem{{SockID: weaveSpecial}},
+			err:   ErrInvalidReservation,
+		},
+	}
+	for backend, open := range testRepositories(t) {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				repo := open(t)
+				s := NewCatalogueService(repo, NewInvertedIndex(), zap.NewNop())
+
+				if _, err := s.Reserve(tt.items, time.Minute); !errors.Is(err, tt.err) {
+					t.Fatalf("reserve: %v, want %v", err, tt.err)
+				}
+				// nothing is held when any item is refused
+				if count, _ := stock(t, repo, weaveSpecial, ""); count != 33 {
+					t.Errorf("count of the sock = %d, want 33", count)
+				}
+				if count, _ := stock(t, repo, superSportXL, "supersport-xl-m"); count != 300 {
+					t.Errorf("count of supersport-xl-m = %d, want 300", count)
+				}
+			})
+		}
+	}
+}
+
+func TestReservationExpires(t *testing.T) {
+	items := []ReservationItem{{SockID: superSportXL, SKU: "supersport-xl-l", Quantity: 20}}
+	for backend, open := range testRepositories(t) {
+		t.Run(backend+"/commit", func(t *testing.T) {
+			repo := open(t)
+			s := NewCatalogueService(repo, NewInvertedIndex(), zap.NewNop())
+
+			r, err := s.Reserve(items, -time.Second)
+			if err != nil {
+				t.Fatalf("reserve: %v", err)
+			}
+			closed, err := s.CommitReservation(r.ID)
+			if !errors.Is(err, ErrReservationExpired) {
+				t.Fatalf("commit: %v, want %v", err, ErrReservationExpired)
+			}
+			if closed.Status != ReservationExpired {
+				t.Errorf("status = %s, want %s", closed.Status, ReservationExpired)
+			}
+			if count, sold := stock(t, repo, superSportXL, "supersport-xl-l"); count != 320 || sold != 0 {
+				t.Errorf("count, sold = %d, %d, want 320, 0", count, sold)
+			}
+		})
+
+		t.Run(backend+"/reaper", func(t *testing.T) {
+			repo := open(t)
+			s := NewCatalogueService(repo, NewInvertedIndex(), zap.NewNop())
+
+			expiring, err := s.Reserve(items, time.Minute)
+			if err != nil {
+				t.Fatalf("reserve: %v", err)
+			}
+			if _, err := s.Reserve(items, time.Hour); err != nil {
+				t.Fatalf("reserve: %v", err)
+			}
+
+			released, err := s.ReleaseExpired(time.Now().Add(2 * time.Minute))
+			if err != nil {
+				t.Fatalf("release expired: %v", err)
+			}
+			if released != 1 {
+				t.Errorf("released %d, want 1", released)
+			}
+			if r, err := s.GetReservation(expiring.ID); err != nil || r.Status != ReservationExpired {
+				t.Errorf("expiring reservation = %s, %v, want %s", r.Status, err, ReservationExpired)
+			}
+			if count, _ := stock(t, repo, superSportXL, "supersport-xl-l"); count != 300 {
+				t.Errorf("count = %d, want 300", count)
+			}
+		})
+	}
+}
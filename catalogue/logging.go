@@ -20,18 +20,18 @@ type loggingMiddleware struct {
 	logger *zap.Logger
 }
 
-func (mw loggingMiddleware) List(tags []string, order string, pageNum, pageSize int) (socks []Sock, err error) {
+func (mw loggingMiddleware) List(filter Filter, page PageRequest) (p Page, err error) {
 	defer func(begin time.Time) {
-		mw.logger.Info("method List", zap.Strings("tags", tags), zap.String("order", order), zap.Int("pageNum", pageNum), zap.Int("pageSize", pageSize), zap.Int("result", len(socks)), zap.Error(err), zap.Duration("took", time.Since(begin)))
+		mw.logger.Info("method List", zap.Strings("tags", filter.Tags), zap.String("match", string(filter.Match)), zap.String("order", filter.Order), zap.Int("pageNum", page.Num), zap.Int("pageSize", page.Size), zap.String("cursor", page.Cursor), zap.Int("result", len(p.Socks)), zap.Int("total", p.Total), zap.Error(err), zap.Duration("took", time.Since(begin)))
 	}(time.Now())
-	return mw.next.List(tags, order, pageNum, pageSize)
+	return mw.next.List(filter, page)
 }
 
-func (mw loggingMiddleware) Count(tags []string) (n int, err error) {
+func (mw loggingMiddleware) Count(filter Filter) (n int, err error) {
 	defer func(begin time.Time) {
-		mw.logger.Info("method Count", zap.Strings("tags", tags), zap.Int("result", n), zap.Error(err), zap.Duration("took", time.Since(begin)))
+		mw.logger.Info("method Count", zap.Strings("tags", filter.Tags), zap.String("match", string(filter.Match)), zap.Int("result", n), zap.Error(err), zap.Duration("took", time.Since(begin)))
 	}(time.Now())
-	return mw.next.Count(tags)
+	return mw.next.Count(filter)
 }
 
 func (mw loggingMiddleware) Get(id string) (s Sock, err error) {
@@ -41,6 +41,13 @@ func (mw loggingMiddleware) Get(id string) (s Sock, err error) {
 	return mw.next.Get(id)
 }
 
+func (mw loggingMiddleware) Search(query SearchQuery) (result SearchResult, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method Search", zap.String("text", query.Text), zap.Strings("tags", query.Tags), zap.String("match", string(query.Match)), zap.Int("pageNum", query.Page.Num), zap.Int("pageSize", query.Page.Size), zap.Int("result", len(result.Hits)), zap.Int("total", result.Total), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.Search(query)
+}
+
 func (mw loggingMiddleware) Tags() (tags []string, err error) {
 	defer func(begin time.Time) {
 		mw.logger.Info("method Tags", zap.Int("result", len(tags)), zap.Error(err), zap.Duration("took", time.Since(begin)))
@@ -48,6 +55,97 @@ func (mw loggingMiddleware) Tags() (tags []string, err error) {
 	return mw.next.Tags()
 }
 
+func (mw loggingMiddleware) CreateSock(sock Sock) (created Sock, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method CreateSock", zap.String("name", sock.Name), zap.String("sock", created.ID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.CreateSock(sock)
+}
+
+func (mw loggingMiddleware) UpdateSock(sock Sock) (updated Sock, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method UpdateSock", zap.String("id", sock.ID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.UpdateSock(sock)
+}
+
+func (mw loggingMiddleware) DeleteSock(id string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method DeleteSock", zap.String("id", id), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.DeleteSock(id)
+}
+
+func (mw loggingMiddleware) CreateTag(name string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method CreateTag", zap.String("tag", name), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.CreateTag(name)
+}
+
+func (mw loggingMiddleware) DeleteTag(name string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method DeleteTag", zap.String("tag", name), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.DeleteTag(name)
+}
+
+func (mw loggingMiddleware) TagSock(id string, tag string) (sock Sock, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method TagSock", zap.String("id", id), zap.String("tag", tag), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.TagSock(id, tag)
+}
+
+func (mw loggingMiddleware) UntagSock(id string, tag string) (sock Sock, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method UntagSock", zap.String("id", id), zap.String("tag", tag), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.UntagSock(id, tag)
+}
+
+func (mw loggingMiddleware) SetImage(id string, slot int, url string) (sock Sock, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method SetImage", zap.String("id", id), zap.Int("slot", slot), zap.String("url", url), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.SetImage(id, slot, url)
+}
+
+func (mw loggingMiddleware) Reserve(items []ReservationItem, ttl time.Duration) (r Reservation, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method Reserve", zap.Int("items", len(items)), zap.Duration("ttl", ttl), zap.String("reservation", r.ID), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.Reserve(items, ttl)
+}
+
+func (mw loggingMiddleware) GetReservation(id string) (r Reservation, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method GetReservation", zap.String("id", id), zap.String("status", string(r.Status)), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.GetReservation(id)
+}
+
+func (mw loggingMiddleware) CommitReservation(id string) (r Reservation, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method CommitReservation", zap.String("id", id), zap.String("status", string(r.Status)), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.CommitReservation(id)
+}
+
+func (mw loggingMiddleware) ReleaseReservation(id string) (r Reservation, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method ReleaseReservation", zap.String("id", id), zap.String("status", string(r.Status)), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.ReleaseReservation(id)
+}
+
+func (mw loggingMiddleware) ReleaseExpired(now time.Time) (n int, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method ReleaseExpired", zap.Int("result", n), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.ReleaseExpired(now)
+}
+
 func (mw loggingMiddleware) Health() (health []Health) {
 	defer func(begin time.Time) {
 		mw.logger.Info("method Health", zap.Int("result", len(health)), zap.Duration("took", time.Since(begin)))
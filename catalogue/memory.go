@@ -0,0 +1,555 @@
+package catalogue
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryRepository keeps the catalogue in maps, for development and for
+// running without a database. Everything is lost when the process exits.
+type MemoryRepository struct {
+	mu           sync.RWMutex
+	socks        map[string]Sock
+	tags         []string
+	reservations map[string]Reservation
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		socks:        map[string]Sock{},
+		tags:         []string{},
+		reservations: map[string]Reservation{},
+	}
+}
+
+// Migrate does nothing, there is no schema to keep up to date.
+func (r *MemoryRepository) Migrate() error {
+	return nil
+}
+
+func (r *MemoryRepository) List(filter Filter, page PageRequest) (Page, error) {
+	order, err := filter.sortOrder()
+	if err != nil {
+		return Page{Socks: []Sock{}}, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	socks := r.filter(filter)
+	total := len(socks)
+	if page.Size <= 0 || (page.Cursor == "" && page.Num <= 0) {
+		return Page{Socks: []Sock{}, Total: total}, nil
+	}
+	sort.Slice(socks, func(i, j int) bool {
+		return order.compare(socks[i], order.value(socks[j]), socks[j].ID) < 0
+	})
+
+	start := 0
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor, order)
+		if err != nil {
+			return Page{Socks: []Sock{}, Total: total}, err
+		}
+		start = sort.Search(len(socks), func(i int) bool {
+			return order.compare(socks[i], c.Value, c.ID) > 0
+		})
+	} else {
+		start = (page.Num - 1) * page.Size
+	}
+	if start > len(socks) {
+		start = len(socks)
+	}
+	socks = socks[start:]
+
+	next := ""
+	if len(socks) > page.Size {
+		socks = socks[:page.Size]
+		next = encodeCursor(order, socks[len(socks)-1])
+	}
+	return Page{Socks: socks, Total: total, Next: next}, nil
+}
+
+func (r *MemoryRepository) Count(filter Filter) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.filter(filter)), nil
+}
+
+// filter returns copies of the socks the filter selects, unordered.
+func (r *MemoryRepository) filter(f Filter) []Sock {
+	tags := uniqueTags(f.Tags)
+	socks := []Sock{}
+	for _, sock := range r.socks {
+		matched := 0
+		for _, t := range tags {
+			if hasTag(sock, t) {
+				matched++
+			}
+		}
+		switch {
+		case len(tags) == 0:
+		case f.Match == MatchAll && matched < len(tags):
+			continue
+		case matched == 0:
+			continue
+		}
+		if len(f.Attributes) > 0 && !hasVariant(sock, f.Attributes) {
+			continue
+		}
+		if !pricedWithin(sock, f.MinPrice, f.MaxPrice) {
+			continue
+		}
+		if f.InStock && !inStock(sock) {
+			continue
+		}
+		socks = append(socks, copySock(sock))
+	}
+	return socks
+}
+
+func (r *MemoryRepository) Facets(filter Filter) (Facets, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	facets := Facets{Tags: map[string]int{}, Prices: newPriceBuckets()}
+	tagFilter, priceFilter := filter.facetFilters()
+	for _, sock := range r.filter(tagFilter) {
+		for _, t := range sock.Tags {
+			facets.Tags[t]++
+		}
+	}
+	for _, sock := range r.filter(priceFilter) {
+		for bucket := range priceBuckets(sock) {
+			facets.Prices[bucket].Count++
+		}
+	}
+	return facets, nil
+}
+
+func (r *MemoryRepository) Get(id string) (Sock, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sock, ok := r.socks[id]
+	if !ok {
+		return Sock{}, ErrNotFound
+	}
+	return copySock(sock), nil
+}
+
+func (r *MemoryRepository) All() ([]Sock, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.filter(Filter{}), nil
+}
+
+func (r *MemoryRepository) Tags() ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]string{}, r.tags...), nil
+}
+
+func (r *MemoryRepository) CreateSock(sock Sock) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.socks[sock.ID]; ok {
+		return fmt.Errorf("%w: sock %q already exists", ErrInvalidSock, sock.ID)
+	}
+	if err := r.checkSKUs(sock.ID, sock.Variants); err != nil {
+		return err
+	}
+	images := append(append([]string(nil), sock.ImageURL...), "", "")
+	sock.ImageURL1, sock.ImageURL2 = images[0], images[1]
+	sock.Tags = uniqueTags(sock.Tags)
+	for _, t := range sock.Tags {
+		r.addTag(t)
+	}
+	r.socks[sock.ID] = copySock(sock)
+	return nil
+}
+
+func (r *MemoryRepository) UpdateSock(sock Sock) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.socks[sock.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := r.checkSKUs(sock.ID, sock.Variants); err != nil {
+		return err
+	}
+	stored.Name = sock.Name
+	stored.Description = sock.Description
+	stored.Price = sock.Price
+	stored.Count = sock.Count
+	if sock.ImageURL != nil {
+		images := append(append([]string(nil), sock.ImageURL...), "", "")
+		stored.ImageURL1, stored.ImageURL2 = images[0], images[1]
+	}
+	if sock.Tags != nil {
+		stored.Tags = uniqueTags(sock.Tags)
+		for _, t := range stored.Tags {
+			r.addTag(t)
+		}
+	}
+	if sock.Variants != nil {
+		stored.Variants = sock.Variants
+	}
+	r.socks[sock.ID] = copySock(stored)
+	return nil
+}
+
+func (r *MemoryRepository) DeleteSock(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.socks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.socks, id)
+	return nil
+}
+
+func (r *MemoryRepository) CreateTag(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addTag(name)
+	return nil
+}
+
+func (r *MemoryRepository) DeleteTag(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := indexOf(r.tags, name)
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.tags = append(r.tags[:i], r.tags[i+1:]...)
+	for id, sock := range r.socks {
+		if j := indexOf(sock.Tags, name); j >= 0 {
+			sock.Tags = append(sock.Tags[:j:j], sock.Tags[j+1:]...)
+			r.socks[id] = sock
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) TagSock(id string, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sock, ok := r.socks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	r.addTag(tag)
+	if !hasTag(sock, tag) {
+		sock.Tags = append(sock.Tags[:len(sock.Tags):len(sock.Tags)], tag)
+		r.socks[id] = sock
+	}
+	return nil
+}
+
+func (r *MemoryRepository) UntagSock(id string, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sock, ok := r.socks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	i := indexOf(sock.Tags, tag)
+	if i < 0 {
+		return ErrNotFound
+	}
+	sock.Tags = append(sock.Tags[:i:i], sock.Tags[i+1:]...)
+	r.socks[id] = sock
+	return nil
+}
+
+func (r *MemoryRepository) SetImage(id string, slot int, url string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sock, ok := r.socks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	switch slot {
+	case 1:
+		sock.ImageURL1 = url
+	case 2:
+		sock.ImageURL2 = url
+	default:
+		return ErrInvalidSlot
+	}
+	r.socks[id] = sock
+	return nil
+}
+
+func (r *MemoryRepository) Reserve(reservation Reservation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, item := range reservation.Items {
+		sock, ok := r.socks[item.SockID]
+		if !ok {
+			return fmt.Errorf("%w: sock %q", ErrNotFound, item.SockID)
+		}
+		if item.SKU != "" {
+			i := variantIndex(sock, item.SKU)
+			if i < 0 {
+				return fmt.Errorf("%w: sku %q of sock %q", ErrNotFound, item.SKU, item.SockID)
+			}
+			if sock.Variants[i].Count < item.Quantity {
+				return fmt.Errorf("%w: sku %q has %d left", ErrInsufficientStock, item.SKU, sock.Variants[i].Count)
+			}
+			continue
+		}
+		if sock.Count < item.Quantity {
+			return fmt.Errorf("%w: sock %q has %d left", ErrInsufficientStock, item.SockID, sock.Count)
+		}
+	}
+	r.addStock(reservation.Items, -1)
+	reservation.Items = append([]ReservationItem{}, reservation.Items...)
+	r.reservations[reservation.ID] = reservation
+	return nil
+}
+
+func (r *MemoryRepository) Reservation(id string) (Reservation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reservation, ok := r.reservations[id]
+	if !ok {
+		return Reservation{}, ErrNotFound
+	}
+	reservation.Items = append([]ReservationItem{}, reservation.Items...)
+	return reservation, nil
+}
+
+func (r *MemoryRepository) UpdateReservation(id string, decide func(r Reservation) (ReservationStatus, error)) (Reservation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reservation, ok := r.reservations[id]
+	if !ok {
+		return Reservation{}, ErrNotFound
+	}
+	reservation.Items = append([]ReservationItem{}, reservation.Items...)
+	status, err := decide(reservation)
+	if err != nil {
+		return Reservation{}, err
+	}
+	if status == reservation.Status {
+		return reservation, nil
+	}
+	if reservation.Status == ReservationHeld {
+		if status == ReservationCommitted {
+			r.addSold(reservation.Items)
+		} else {
+			r.addStock(reservation.Items, 1)
+		}
+	}
+	reservation.Status = status
+	r.reservations[id] = reservation
+	return reservation, nil
+}
+
+func (r *MemoryRepository) HeldReservations(before time.Time) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := []string{}
+	for id, reservation := range r.reservations {
+		if reservation.Status == ReservationHeld && !reservation.ExpiresAt.After(before) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (r *MemoryRepository) Ping() error {
+	return nil
+}
+
+func (r *MemoryRepository) Close() error {
+	return nil
+}
+
+// addStock adds the quantities of the items to the counts of their socks,
+// or of their variants, sign times over.
+func (r *MemoryRepository) addStock(items []ReservationItem, sign int) {
+	for _, item := range items {
+		sock, ok := r.socks[item.SockID]
+		if !ok {
+			continue
+		}
+		if item.SKU == "" {
+			sock.Count += sign * item.Quantity
+		} else if i := variantIndex(sock, item.SKU); i >= 0 {
+			sock.Variants = copyVariants(sock.Variants)
+			sock.Variants[i].Count += sign * item.Quantity
+		}
+		r.socks[item.SockID] = sock
+	}
+}
+
+func variantIndex(sock Sock, sku string) int {
+	for i, v := range sock.Variants {
+		if v.SKU == sku {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkSKUs fails if the SKU of one of the variants belongs to another sock.
+func (r *MemoryRepository) checkSKUs(id string, variants []Variant) error {
+	for _, v := range variants {
+		for _, sock := range r.socks {
+			if sock.ID == id {
+				continue
+			}
+			for _, other := range sock.Variants {
+				if other.SKU == v.SKU {
+					return fmt.Errorf("%w: sku %q belongs to sock %q", ErrInvalidVariant, v.SKU, sock.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) addSold(items []ReservationItem) {
+	for _, item := range items {
+		if sock, ok := r.socks[item.SockID]; ok {
+			sock.Sold += item.Quantity
+			r.socks[item.SockID] = sock
+		}
+	}
+}
+
+func (r *MemoryRepository) addTag(name string) {
+	if indexOf(r.tags, name) < 0 {
+		r.tags = append(r.tags, name)
+	}
+}
+
+// copySock returns the sock with slices of its own, as the SQL repositories
+// would read it back.
+func copySock(sock Sock) Sock {
+	sock.ImageURL = []string{sock.ImageURL1, sock.ImageURL2}
+	sock.Tags = append([]string{}, sock.Tags...)
+	sock.TagString = strings.Join(sock.Tags, ",")
+	sock.Variants = copyVariants(sock.Variants)
+	for i := range sock.Variants {
+		sock.Variants[i].SockID = sock.ID
+	}
+	sort.Slice(sock.Variants, func(i, j int) bool { return sock.Variants[i].SKU < sock.Variants[j].SKU })
+	return sock
+}
+
+func hasTag(sock Sock, tag string) bool {
+	return indexOf(sock.Tags, tag) >= 0
+}
+
+// pricedWithin reports whether the sock, or one of its variants, is priced
+// within the bounds that are above zero.
+func pricedWithin(sock Sock, min, max float32) bool {
+	within := func(price float32) bool {
+		return (min <= 0 || price >= min) && (max <= 0 || price <= max)
+	}
+	if within(sock.Price) {
+		return true
+	}
+	for _, v := range sock.Variants {
+		if within(v.Price) {
+			return true
+		}
+	}
+	return false
+}
+
+func inStock(sock Sock) bool {
+	if sock.Count > 0 {
+		return true
+	}
+	for _, v := range sock.Variants {
+		if v.Count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVariant(sock Sock, attributes map[string]string) bool {
+	for _, v := range sock.Variants {
+		if v.hasAttributes(attributes) {
+			return true
+		}
+	}
+	return false
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// compare orders sock against the sort value and id of another sock, the way
+// the ORDER BY of the sort does.
+func (o sortOrder) compare(sock Sock, value string, id string) int {
+	c := 0
+	switch o.key {
+	case "name":
+		c = strings.Compare(sock.Name, value)
+	case "price":
+		p, _ := strconv.ParseFloat(value, 32)
+		c = compareFloats(float64(sock.Price), float64(float32(p)))
+	case "count":
+		n, _ := strconv.Atoi(value)
+		c = compareFloats(float64(sock.Count), float64(n))
+	case "newest":
+		n, _ := strconv.ParseInt(value, 10, 64)
+		c = compareFloats(float64(sock.Created), float64(n))
+	case "popularity":
+		n, _ := strconv.Atoi(value)
+		c = compareFloats(float64(sock.Sold), float64(n))
+	default:
+		c = strings.Compare(sock.ID, id)
+	}
+	if o.desc {
+		c = -c
+	}
+	if c == 0 {
+		c = strings.Compare(sock.ID, id)
+	}
+	return c
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,214 @@
+package catalogue
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migration is a schema change, applied as a whole or not at all where the
+// database allows it. MySQL commits DDL as it goes, so a migration that
+// fails there part way may need finishing by hand. The tables of the first
+// migrations are created only if they do not exist, to adopt databases set
+// up before migrations were recorded.
+type migration []string
+
+// mysqlMigrations are the schema changes of the catalogue, in order. The
+// version of a migration is its index plus one; never edit or reorder one
+// that has been released, append a new one.
+var mysqlMigrations = []migration{
+	{
+		`CREATE TABLE IF NOT EXISTS sock (
+			sock_id varchar(40) NOT NULL,
+			name varchar(40),
+			description varchar(255),
+			price float,
+			count int,
+			image_url_1 varchar(255),
+			image_url_2 varchar(255),
+			PRIMARY KEY (sock_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS tag (
+			tag_id int NOT NULL AUTO_INCREMENT,
+			name varchar(40),
+			PRIMARY KEY (tag_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS sock_tag (
+			sock_id varchar(40),
+			tag_id int NOT NULL,
+			FOREIGN KEY (sock_id) REFERENCES sock (sock_id),
+			FOREIGN KEY (tag_id) REFERENCES tag (tag_id)
+		);`,
+	},
+	{
+		// Times are kept as unix seconds so that they read back whatever the
+		// DSN's parseTime setting.
+		`CREATE TABLE IF NOT EXISTS reservation (
+			reservation_id varchar(40) NOT NULL,
+			status varchar(20) NOT NULL,
+			created_at bigint NOT NULL,
+			expires_at bigint NOT NULL,
+			PRIMARY KEY (reservation_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS reservation_item (
+			reservation_id varchar(40) NOT NULL,
+			sock_id varchar(40) NOT NULL,
+			quantity int NOT NULL,
+			PRIMARY KEY (reservation_id, sock_id)
+		);`,
+	},
+	{
+		`CREATE TABLE IF NOT EXISTS variant (
+			sku varchar(40) NOT NULL,
+			sock_id varchar(40) NOT NULL,
+			price float NOT NULL,
+			count int NOT NULL,
+			PRIMARY KEY (sku),
+			FOREIGN KEY (sock_id) REFERENCES sock (sock_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS variant_attribute (
+			sku varchar(40) NOT NULL,
+			name varchar(40) NOT NULL,
+			value varchar(40) NOT NULL,
+			PRIMARY KEY (sku, name),
+			FOREIGN KEY (sku) REFERENCES variant (sku)
+		);`,
+	},
+	{
+		// created_at is in unix seconds, like the times of reservations;
+		// sold counts the units of committed reservations.
+		`ALTER TABLE sock ADD COLUMN created_at bigint NOT NULL DEFAULT 0;`,
+		`ALTER TABLE sock ADD COLUMN sold int NOT NULL DEFAULT 0;`,
+	},
+	{
+		// Tags created twice by concurrent writes are folded into the
+		// first of them before names are made unique.
+		`UPDATE sock_tag
+			JOIN tag ON tag.tag_id = sock_tag.tag_id
+			JOIN (SELECT name, MIN(tag_id) AS tag_id FROM tag GROUP BY name) kept ON kept.name = tag.name
+			SET sock_tag.tag_id = kept.tag_id;`,
+		`CREATE TABLE sock_tag_distinct AS SELECT DISTINCT sock_id, tag_id FROM sock_tag;`,
+		`DELETE FROM sock_tag;`,
+		`INSERT INTO sock_tag (sock_id, tag_id) SELECT sock_id, tag_id FROM sock_tag_distinct;`,
+		`DROP TABLE sock_tag_distinct;`,
+		`DELETE tag FROM tag JOIN tag kept ON kept.name = tag.name AND kept.tag_id < tag.tag_id;`,
+		`CREATE UNIQUE INDEX tag_name ON tag (name);`,
+	},
+	{
+		// Reservations may hold the stock of a variant; the sku of an item
+		// holding the sock's own stock is empty.
+		`ALTER TABLE reservation_item
+			ADD COLUMN sku varchar(40) NOT NULL DEFAULT '',
+			DROP PRIMARY KEY,
+			ADD PRIMARY KEY (reservation_id, sock_id, sku);`,
+	},
+}
+
+var sqliteMigrations = []migration{
+	{
+		`CREATE TABLE IF NOT EXISTS sock (
+			sock_id TEXT NOT NULL PRIMARY KEY,
+			name TEXT,
+			description TEXT,
+			price REAL,
+			count INTEGER,
+			image_url_1 TEXT,
+			image_url_2 TEXT
+		);`,
+		`CREATE TABLE IF NOT EXISTS tag (
+			tag_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT
+		);`,
+		`CREATE TABLE IF NOT EXISTS sock_tag (
+			sock_id TEXT REFERENCES sock (sock_id),
+			tag_id INTEGER NOT NULL REFERENCES tag (tag_id)
+		);`,
+	},
+	{
+		`CREATE TABLE IF NOT EXISTS reservation (
+			reservation_id TEXT NOT NULL PRIMARY KEY,
+			status TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS reservation_item (
+			reservation_id TEXT NOT NULL,
+			sock_id TEXT NOT NULL,
+			quantity INTEGER NOT NULL,
+			PRIMARY KEY (reservation_id, sock_id)
+		);`,
+	},
+	{
+		`CREATE TABLE IF NOT EXISTS variant (
+			sku TEXT NOT NULL PRIMARY KEY,
+			sock_id TEXT NOT NULL REFERENCES sock (sock_id),
+			price REAL NOT NULL,
+			count INTEGER NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS variant_sock_id ON variant (sock_id);`,
+		`CREATE TABLE IF NOT EXISTS variant_attribute (
+			sku TEXT NOT NULL REFERENCES variant (sku),
+			name TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (sku, name)
+		);`,
+	},
+	{
+		`ALTER TABLE sock ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE sock ADD COLUMN sold INTEGER NOT NULL DEFAULT 0;`,
+	},
+	{
+		`UPDATE sock_tag SET tag_id = (
+				SELECT MIN(kept.tag_id) FROM tag JOIN tag kept ON kept.name = tag.name
+				WHERE tag.tag_id = sock_tag.tag_id
+			)
+			WHERE tag_id IN (SELECT tag_id FROM tag);`,
+		`DELETE FROM sock_tag WHERE rowid NOT IN (SELECT MIN(rowid) FROM sock_tag GROUP BY sock_id, tag_id);`,
+		`DELETE FROM tag WHERE tag_id NOT IN (SELECT MIN(tag_id) FROM tag GROUP BY name);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS tag_name ON tag (name);`,
+	},
+	{
+		// SQLite cannot change a primary key, so the table is rebuilt.
+		`CREATE TABLE reservation_item_sku (
+				reservation_id TEXT NOT NULL,
+				sock_id TEXT NOT NULL,
+				sku TEXT NOT NULL DEFAULT '',
+				quantity INTEGER NOT NULL,
+				PRIMARY KEY (reservation_id, sock_id, sku)
+			);`,
+		`INSERT INTO reservation_item_sku (reservation_id, sock_id, quantity)
+			SELECT reservation_id, sock_id, quantity FROM reservation_item;`,
+		`DROP TABLE reservation_item;`,
+		`ALTER TABLE reservation_item_sku RENAME TO reservation_item;`,
+	},
+}
+
+// Migrate applies the migrations of the dialect newer than the version
+// recorded in schema_migrations.
+func (r *SQLRepository) Migrate() error {
+	if _, err := r.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version int NOT NULL PRIMARY KEY);"); err != nil {
+		return err
+	}
+	var version int
+	if err := r.db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations;"); err != nil {
+		return err
+	}
+	for i := version; i < len(r.dialect.migrations); i++ {
+		if err := r.migrate(i+1, r.dialect.migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepository) migrate(version int, m migration) error {
+	return r.withTx(func(tx *sqlx.Tx) error {
+		for _, statement := range m {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?);", version)
+		return err
+	})
+}
@@ -0,0 +1,75 @@
+package catalogue
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// MaxPageSize bounds the socks a page may hold, so that a request for a huge
+// page does not read and send the whole catalogue. Larger sizes are clamped
+// to it.
+const MaxPageSize = 100
+
+// PageRequest selects a page of socks, either by number or, when Cursor is
+// set, as the page following the cursor.
+type PageRequest struct {
+	Num    int
+	Size   int
+	Cursor string
+}
+
+// Page is a page of socks along with how many socks the filter matches, the
+// cursor of the next page, empty on the last page, and the facets of the
+// socks the filter matches.
+type Page struct {
+	Socks  []Sock
+	Total  int
+	Next   string
+	Facets Facets
+}
+
+// cursor is the position of the last sock of a page in the order the page
+// was sorted by. It is handed out opaque so that clients do not depend on
+// its contents.
+type cursor struct {
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func encodeCursor(order sortOrder, sock Sock) string {
+	c := cursor{
+		Order: order.key,
+		Value: order.value(sock),
+		ID:    sock.ID,
+	}
+	if order.desc {
+		c.Order = "-" + c.Order
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor, which must have been taken in the given
+// order.
+func decodeCursor(s string, order sortOrder) (cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	key := order.key
+	if order.desc {
+		key = "-" + key
+	}
+	if c.Order != key || c.ID == "" {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
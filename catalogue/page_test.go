@@ -0,0 +1,95 @@
+package catalogue
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+	}{
+		{name: "id", filter: Filter{}},
+		{name: "name descending", filter: Filter{Order: "-name"}},
+		{name: "price", filter: Filter{Order: "price"}},
+		{name: "count", filter: Filter{Order: "count desc"}},
+		{name: "newest", filter: Filter{Order: "newest"}},
+		{name: "popularity", filter: Filter{Order: "popularity"}},
+		{name: "tag", filter: Filter{Tags: []string{"brown"}, Order: "price"}},
+		{name: "in stock", filter: Filter{InStock: true, Order: "-price"}},
+	}
+	for backend, open := range testRepositories(t) {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				s := NewCatalogueService(open(t), NewInvertedIndex(), zap.NewNop())
+
+				all, err := s.List(tt.filter, PageRequest{Num: 1, Size: MaxPageSize})
+				if err != nil {
+					t.Fatalf("list: %v", err)
+				}
+				if len(all.Socks) == 0 {
+					t.Fatal("the filter matches no socks")
+				}
+
+				var paged []string
+				page := PageRequest{Num: 1, Size: 2}
+				for {
+					result, err := s.List(tt.filter, page)
+					if err != nil {
+						t.Fatalf("list after %q: %v", page.Cursor, err)
+					}
+					for _, sock := range result.Socks {
+						paged = append(paged, sock.ID)
+					}
+					if result.Next == "" {
+						break
+					}
+					if len(paged) > len(all.Socks) {
+						t.Fatalf("paged through %d socks of %d", len(paged), len(all.Socks))
+					}
+					page.Cursor = result.Next
+				}
+
+				if len(paged) != len(all.Socks) {
+					t.Fatalf("paged through %d socks, want %d", len(paged), len(all.Socks))
+				}
+				for i, sock := range all.Socks {
+					if paged[i] != sock.ID {
+						t.Errorf("sock %d = %s, want %s", i, paged[i], sock.ID)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	for backend, open := range testRepositories(t) {
+		t.Run(backend, func(t *testing.T) {
+			s := NewCatalogueService(open(t), NewInvertedIndex(), zap.NewNop())
+
+			first, err := s.List(Filter{Order: "price"}, PageRequest{Num: 1, Size: 2})
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			tests := []struct {
+				name   string
+				filter Filter
+				cursor string
+			}{
+				{name: "not base64", filter: Filter{Order: "price"}, cursor: "not a cursor!"},
+				{name: "not json", filter: Filter{Order: "price"}, cursor: "bm90IGpzb24"},
+				{name: "another order", filter: Filter{Order: "name"}, cursor: first.Next},
+				{name: "another direction", filter: Filter{Order: "-price"}, cursor: first.Next},
+			}
+			for _, tt := range tests {
+				if _, err := s.List(tt.filter, PageRequest{Size: 2, Cursor: tt.cursor}); !errors.Is(err, ErrInvalidCursor) {
+					t.Errorf("%s: %v, want %v", tt.name, err, ErrInvalidCursor)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package catalogue
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TagMatch is how the tags of a filter combine.
+type TagMatch string
+
+const (
+	// MatchAny selects socks with at least one of the tags.
+	MatchAny TagMatch = "any"
+	// MatchAll selects socks with every one of the tags.
+	MatchAll TagMatch = "all"
+)
+
+var (
+	ErrInvalidSort  = errors.New("invalid sort")
+	ErrInvalidMatch = errors.New("invalid tag match")
+	ErrInvalidPrice = errors.New("invalid price range")
+)
+
+// sortColumns whitelists the keys socks may be sorted by and the column
+// each sorts on.
+var sortColumns = map[string]string{
+	"id":         "sock.sock_id",
+	"name":       "sock.name",
+	"price":      "sock.price",
+	"count":      "sock.count",
+	"newest":     "sock.created_at",
+	"popularity": "sock.sold",
+}
+
+// descendingKeys sort in descending order unless asked otherwise, so that
+// the newest and the most popular socks come first.
+var descendingKeys = map[string]bool{
+	"newest":     true,
+	"popularity": true,
+}
+
+// Filter selects and orders socks.
+type Filter struct {
+	Tags  []string
+	Match TagMatch
+	// Attributes selects socks with a variant that has every one of them.
+	Attributes map[string]string
+	// MinPrice and MaxPrice bound the price of socks, of their own or of a
+	// variant, inclusively, when they are above zero.
+	MinPrice float32
+	MaxPrice float32
+	// InStock selects socks with stock left, of their own or of a variant.
+	InStock bool
+	// Order is a sort key, descending when prefixed with "-" or suffixed
+	// with " desc", ascending when suffixed with " asc", and otherwise in
+	// the key's own direction.
+	Order string
+}
+
+// Validate checks the filter only refers to known sort keys and tag
+// matches.
+func (f Filter) Validate() error {
+	switch f.Match {
+	case "", MatchAny, MatchAll:
+	default:
+		return fmt.Errorf("%w %q", ErrInvalidMatch, f.Match)
+	}
+	for name, value := range f.Attributes {
+		if err := validateAttribute(name, value); err != nil {
+			return err
+		}
+	}
+	if f.MinPrice < 0 || f.MaxPrice < 0 || (f.MaxPrice > 0 && f.MaxPrice < f.MinPrice) {
+		return fmt.Errorf("%w %v-%v", ErrInvalidPrice, f.MinPrice, f.MaxPrice)
+	}
+	_, err := f.sortOrder()
+	return err
+}
+
+// sortOrder is the whitelisted column a filter sorts on.
+type sortOrder struct {
+	key    string
+	column string
+	desc   bool
+}
+
+// sortOrder parses the sort key of the filter.
+func (f Filter) sortOrder() (sortOrder, error) {
+	key := strings.ToLower(strings.TrimSpace(f.Order))
+	if key == "" {
+		key = "id"
+	}
+	direction := ""
+	switch {
+	case strings.HasPrefix(key, "-"):
+		key, direction = strings.TrimPrefix(key, "-"), "DESC"
+	case strings.HasSuffix(key, " desc"):
+		key, direction = strings.TrimSpace(strings.TrimSuffix(key, " desc")), "DESC"
+	case strings.HasSuffix(key, " asc"):
+		key, direction = strings.TrimSpace(strings.TrimSuffix(key, " asc")), "ASC"
+	}
+	column, ok := sortColumns[key]
+	if !ok {
+		return sortOrder{}, fmt.Errorf("%w %q", ErrInvalidSort, f.Order)
+	}
+	if direction == "" && descendingKeys[key] {
+		direction = "DESC"
+	}
+	return sortOrder{key: key, column: column, desc: direction == "DESC"}, nil
+}
+
+// orderBy is the ORDER BY clause of the sort, breaking ties by id so pages
+// are stable.
+func (o sortOrder) orderBy() string {
+	direction := "ASC"
+	if o.desc {
+		direction = "DESC"
+	}
+	if o.key == "id" {
+		return fmt.Sprintf(" ORDER BY %s %s", o.column, direction)
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, %s ASC", o.column, direction, sortColumns["id"])
+}
+
+// after adds the condition selecting the socks that sort after the one the
+// cursor was taken at.
+func (o sortOrder) after(q *query, c cursor) {
+	comparison := ">"
+	if o.desc {
+		comparison = "<"
+	}
+	if o.key == "id" {
+		q.where(fmt.Sprintf("%s %s ?", o.column, comparison), c.ID)
+		return
+	}
+	q.where(fmt.Sprintf("(%s %s ? OR (%s = ? AND %s > ?))", o.column, comparison, o.column, sortColumns["id"]), o.bind(c.Value), o.bind(c.Value), c.ID)
+}
+
+// bind converts a value kept in a cursor back to the type of the sort
+// column. Prices are widened from float32 the way the column reads back, or
+// socks priced the same as the cursor would not compare equal to it.
+func (o sortOrder) bind(value string) interface{} {
+	switch o.key {
+	case "price":
+		if p, err := strconv.ParseFloat(value, 32); err == nil {
+			return float64(float32(p))
+		}
+	case "count", "popularity":
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	case "newest":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	}
+	return value
+}
+
+// value is the value of the sort column of sock, as it is kept in a cursor.
+func (o sortOrder) value(sock Sock) string {
+	switch o.key {
+	case "name":
+		return sock.Name
+	case "price":
+		return strconv.FormatFloat(float64(sock.Price), 'f', -1, 32)
+	case "count":
+		return strconv.Itoa(sock.Count)
+	case "newest":
+		return strconv.FormatInt(sock.Created, 10)
+	case "popularity":
+		return strconv.Itoa(sock.Sold)
+	default:
+		return sock.ID
+	}
+}
+
+// query accumulates the conditions of a statement along with the values
+// bound to their placeholders.
+type query struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (q *query) where(condition string, args ...interface{}) {
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, args...)
+}
+
+func (q *query) clause() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+// placeholders returns n comma separated bind placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// filterQuery builds the conditions selecting the socks of the filter. Tags
+// are matched in a subquery so the tags listed with each sock are not
+// narrowed down to the ones filtered on.
+func filterQuery(f Filter) *query {
+	q := new(query)
+	if f.MinPrice > 0 || f.MaxPrice > 0 {
+		priceQuery(q, f.MinPrice, f.MaxPrice)
+	}
+	if f.InStock {
+		q.where("(sock.count > 0 OR EXISTS (SELECT 1 FROM variant WHERE variant.sock_id=sock.sock_id AND variant.count > 0))")
+	}
+	if len(f.Attributes) > 0 {
+		attributesQuery(q, f.Attributes)
+	}
+	tags := uniqueTags(f.Tags)
+	if len(tags) == 0 {
+		return q
+	}
+	args := make([]interface{}, 0, len(tags)+1)
+	for _, t := range tags {
+		args = append(args, t)
+	}
+	subquery := "SELECT sock_tag.sock_id FROM sock_tag JOIN tag ON sock_tag.tag_id=tag.tag_id WHERE tag.name IN (" + placeholders(len(tags)) + ")"
+	if f.Match == MatchAll {
+		subquery += " GROUP BY sock_tag.sock_id HAVING COUNT(DISTINCT tag.name) = ?"
+		args = append(args, len(tags))
+	}
+	q.where("sock.sock_id IN ("+subquery+")", args...)
+	return q
+}
+
+// priceQuery adds the condition selecting the socks priced within the
+// bounds, either themselves or in one of their variants.
+func priceQuery(q *query, min, max float32) {
+	within := func(column string) string {
+		var bounds []string
+		if min > 0 {
+			bounds = append(bounds, column+" >= ?")
+		}
+		if max > 0 {
+			bounds = append(bounds, column+" <= ?")
+		}
+		return strings.Join(bounds, " AND ")
+	}
+	var args []interface{}
+	if min > 0 {
+		args = append(args, float64(min))
+	}
+	if max > 0 {
+		args = append(args, float64(max))
+	}
+	q.where("(("+within("sock.price")+") OR EXISTS (SELECT 1 FROM variant WHERE variant.sock_id=sock.sock_id AND "+within("variant.price")+"))",
+		append(args, args...)...)
+}
+
+// attributesQuery adds the condition selecting the socks with a variant that
+// has every one of the attributes.
+func attributesQuery(q *query, attributes map[string]string) {
+	names := attributeNames(attributes)
+	pairs := make([]string, len(names))
+	args := make([]interface{}, 0, 2*len(names)+1)
+	for i, name := range names {
+		pairs[i] = "(variant_attribute.name = ? AND variant_attribute.value = ?)"
+		args = append(args, name, attributes[name])
+	}
+	args = append(args, len(names))
+	q.where("sock.sock_id IN (SELECT variant.sock_id FROM variant JOIN variant_attribute ON variant.sku=variant_attribute.sku WHERE "+
+		strings.Join(pairs, " OR ")+" GROUP BY variant.sku, variant.sock_id HAVING COUNT(*) = ?)", args...)
+}
+
+func uniqueTags(tags []string) []string {
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		unique = append(unique, t)
+	}
+	return unique
+}
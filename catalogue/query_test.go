@@ -0,0 +1,62 @@
+package catalogue
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPriceFilter(t *testing.T) {
+	// priced at 8 in small and at 60 in large
+	tiered := Sock{ID: "tiered", Name: "Tiered", Price: 8, Variants: []Variant{
+		{SKU: "tiered-s", Price: 8, Count: 1},
+		{SKU: "tiered-l", Price: 60, Count: 1},
+	}}
+	tests := []struct {
+		name     string
+		min, max float32
+		want     []string
+	}{
+		{name: "own price", min: 7, max: 9, want: []string{"a0a4f044-b040-410d-8ead-4de0446aec7e", "tiered"}},
+		{name: "variant price", min: 16, max: 17, want: []string{superSportXL}},
+		{name: "above", min: 55, want: []string{"03fef6ac-1896-4ce8-bd69-b798f85c6e0b", "tiered"}},
+	}
+	for backend, open := range testRepositories(t) {
+		repo := open(t)
+		if err := repo.CreateSock(tiered); err != nil {
+			t.Fatalf("%s: create sock: %v", backend, err)
+		}
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				page, err := repo.List(Filter{MinPrice: tt.min, MaxPrice: tt.max}, PageRequest{Num: 1, Size: MaxPageSize})
+				if err != nil {
+					t.Fatalf("list: %v", err)
+				}
+				ids := []string{}
+				for _, sock := range page.Socks {
+					ids = append(ids, sock.ID)
+				}
+				sort.Strings(ids)
+				if !reflect.DeepEqual(ids, tt.want) {
+					t.Errorf("socks = %q, want %q", ids, tt.want)
+				}
+			})
+		}
+
+		t.Run(backend+"/facets", func(t *testing.T) {
+			facets, err := repo.Facets(Filter{MinPrice: 55})
+			if err != nil {
+				t.Fatalf("facets: %v", err)
+			}
+			counts := []int{}
+			for _, bucket := range facets.Prices {
+				counts = append(counts, bucket.Count)
+			}
+			// prices are not narrowed down by their own bounds, and the
+			// tiered sock counts once under 10 and once from 50
+			if want := []int{2, 3, 5, 0, 2}; !reflect.DeepEqual(counts, want) {
+				t.Errorf("price facet = %v, want %v", counts, want)
+			}
+		})
+	}
+}
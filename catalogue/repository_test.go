@@ -0,0 +1,55 @@
+package catalogue
+
+import (
+	"testing"
+)
+
+// testRepositories opens each backend of the catalogue afresh, migrated and
+// filled with the seed socks.
+func testRepositories(t *testing.T) map[string]func(t *testing.T) Repository {
+	t.Helper()
+	open := func(dsn string) func(t *testing.T) Repository {
+		return func(t *testing.T) Repository {
+			t.Helper()
+			repo, err := OpenRepository(dsn)
+			if err != nil {
+				t.Fatalf("open %s: %v", dsn, err)
+			}
+			t.Cleanup(func() { repo.Close() })
+			if err := repo.Migrate(); err != nil {
+				t.Fatalf("migrate %s: %v", dsn, err)
+			}
+			if _, err := Seed(repo); err != nil {
+				t.Fatalf("seed %s: %v", dsn, err)
+			}
+			return repo
+		}
+	}
+	return map[string]func(t *testing.T) Repository{
+		"memory": open("memory://"),
+		"sqlite": open("sqlite://:memory:"),
+	}
+}
+
+func TestCreateSockLeavesImagesAlone(t *testing.T) {
+	for backend, open := range testRepositories(t) {
+		t.Run(backend, func(t *testing.T) {
+			repo := open(t)
+			// spare capacity the repository must not write into
+			backing := []string{"/catalogue/images/one.jpg", "kept", "kept"}
+			images := backing[:1]
+
+			sock := Sock{ID: "spare", Name: "Spare", ImageURL: images}
+			if err := repo.CreateSock(sock); err != nil {
+				t.Fatalf("create sock: %v", err)
+			}
+			sock.Name = "Spared"
+			if err := repo.UpdateSock(sock); err != nil {
+				t.Fatalf("update sock: %v", err)
+			}
+			if backing[1] != "kept" || backing[2] != "kept" {
+				t.Errorf("images written past the caller's slice: %q", backing)
+			}
+		})
+	}
+}
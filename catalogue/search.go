@@ -0,0 +1,309 @@
+package catalogue
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var ErrEmptySearch = errors.New("empty search")
+
+// SearchQuery is a text search over socks, optionally narrowed to tags.
+type SearchQuery struct {
+	Text  string
+	Tags  []string
+	Match TagMatch
+	Page  PageRequest
+}
+
+// Hit is a sock matching a search along with its relevance.
+type Hit struct {
+	Sock
+	Score float64 `json:"score"`
+}
+
+// SearchResult is a page of hits in order of relevance, how many socks
+// matched and how many of those carry each tag.
+type SearchResult struct {
+	Hits   []Hit
+	Total  int
+	Facets map[string]int
+}
+
+// Index is a text index of socks.
+type Index interface {
+	// Rebuild replaces the contents of the index with socks.
+	Rebuild(socks []Sock) error
+	// Put adds or replaces a sock.
+	Put(sock Sock) error
+	// Delete removes a sock.
+	Delete(id string) error
+	Search(query SearchQuery) (SearchResult, error)
+}
+
+// RebuildIndex loads every sock of the repository into the index.
+func RebuildIndex(repo Repository, index Index) error {
+	socks, err := repo.All()
+	if err != nil {
+		return err
+	}
+	return index.Rebuild(socks)
+}
+
+const (
+	nameWeight        = 2.0
+	descriptionWeight = 1.0
+	// fuzzyPenalty scales the score of terms matched despite a typo.
+	fuzzyPenalty = 0.5
+)
+
+// InvertedIndex is an in-process Index mapping each term to the socks it
+// appears in. Typos are tolerated by matching terms within a small edit
+// distance, scanning the vocabulary, which suits catalogues of thousands
+// rather than millions of socks.
+type InvertedIndex struct {
+	mu       sync.RWMutex
+	socks    map[string]Sock
+	postings map[string]map[string]float64
+}
+
+func NewInvertedIndex() *InvertedIndex {
+	return &InvertedIndex{
+		socks:    map[string]Sock{},
+		postings: map[string]map[string]float64{},
+	}
+}
+
+func (ix *InvertedIndex) Rebuild(socks []Sock) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.socks = map[string]Sock{}
+	ix.postings = map[string]map[string]float64{}
+	for _, sock := range socks {
+		ix.put(sock)
+	}
+	return nil
+}
+
+func (ix *InvertedIndex) Put(sock Sock) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.delete(sock.ID)
+	ix.put(sock)
+	return nil
+}
+
+func (ix *InvertedIndex) Delete(id string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.delete(id)
+	return nil
+}
+
+func (ix *InvertedIndex) put(sock Sock) {
+	ix.socks[sock.ID] = sock
+	weights := map[string]float64{}
+	for _, t := range tokenize(sock.Name) {
+		weights[t] += nameWeight
+	}
+	for _, t := range tokenize(sock.Description) {
+		weights[t] += descriptionWeight
+	}
+	for t, w := range weights {
+		if ix.postings[t] == nil {
+			ix.postings[t] = map[string]float64{}
+		}
+		ix.postings[t][sock.ID] = w
+	}
+}
+
+func (ix *InvertedIndex) delete(id string) {
+	if _, ok := ix.socks[id]; !ok {
+		return
+	}
+	delete(ix.socks, id)
+	for t, posting := range ix.postings {
+		delete(posting, id)
+		if len(posting) == 0 {
+			delete(ix.postings, t)
+		}
+	}
+}
+
+func (ix *InvertedIndex) Search(query SearchQuery) (SearchResult, error) {
+	terms := tokenize(query.Text)
+	if len(terms) == 0 {
+		return SearchResult{Hits: []Hit{}, Facets: map[string]int{}}, ErrEmptySearch
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	scores := map[string]float64{}
+	for _, term := range terms {
+		for candidate, penalty := range ix.expand(term) {
+			posting := ix.postings[candidate]
+			idf := math.Log(1 + float64(len(ix.socks))/float64(len(posting)))
+			for id, w := range posting {
+				scores[id] += w * idf * penalty
+			}
+		}
+	}
+
+	tags := uniqueTags(query.Tags)
+	hits := make([]Hit, 0, len(scores))
+	facets := map[string]int{}
+	for id, score := range scores {
+		sock := ix.socks[id]
+		if !hasTags(sock, tags, query.Match) {
+			continue
+		}
+		for _, t := range sock.Tags {
+			facets[t]++
+		}
+		hits = append(hits, Hit{Sock: sock, Score: math.Round(score*1000) / 1000})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+
+	result := SearchResult{Total: len(hits), Facets: facets}
+	start := (query.Page.Num - 1) * query.Page.Size
+	if query.Page.Num <= 0 || query.Page.Size <= 0 || start >= len(hits) {
+		result.Hits = []Hit{}
+		return result, nil
+	}
+	end := start + query.Page.Size
+	if end > len(hits) {
+		end = len(hits)
+	}
+	result.Hits = hits[start:end]
+	return result, nil
+}
+
+// expand returns the indexed terms matching term along with the factor
+// their score is scaled by: the term itself, and terms within the edit
+// distance its length tolerates.
+func (ix *InvertedIndex) expand(term string) map[string]float64 {
+	matches := map[string]float64{}
+	if _, ok := ix.postings[term]; ok {
+		matches[term] = 1
+	}
+	limit := typoLimit(term)
+	if limit == 0 {
+		return matches
+	}
+	for candidate := range ix.postings {
+		if candidate == term {
+			continue
+		}
+		if d := editDistance(term, candidate, limit); d <= limit {
+			matches[candidate] = fuzzyPenalty / float64(d)
+		}
+	}
+	return matches
+}
+
+// typoLimit is how many edits a query term may be away from an indexed
+// term, none for short terms where a typo is likely another word.
+func typoLimit(term string) int {
+	switch n := len([]rune(term)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func hasTags(sock Sock, tags []string, match TagMatch) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	has := map[string]bool{}
+	for _, t := range sock.Tags {
+		has[t] = true
+	}
+	found := 0
+	for _, t := range tags {
+		if has[t] {
+			found++
+		}
+	}
+	if match == MatchAll {
+		return found == len(tags)
+	}
+	return found > 0
+}
+
+// tokenize lowercases text and splits it into words, folding simple plurals
+// so that "socks" finds "sock".
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		if len(w) < 2 {
+			continue
+		}
+		if len(w) > 3 && strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") {
+			w = strings.TrimSuffix(w, "s")
+		}
+		tokens = append(tokens, w)
+	}
+	return tokens
+}
+
+// editDistance is the Levenshtein distance between a and b, or limit+1 once
+// it is known to exceed limit.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		best := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < best {
+				best = curr[j]
+			}
+		}
+		if best > limit {
+			return limit + 1
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,120 @@
+package catalogue
+
+// seedSocks is the catalogue the sock shop has always shipped with.
+var seedSocks = []Sock{
+	{
+		ID:          "6d62d909-f957-430e-8689-b5129c0bb75e",
+		Name:        "Weave special",
+		Description: "Limited issue Mr Fantastic signature socks. Made from thousands of tiny strands woven into a stunning pair, twisted and reimagined.",
+		ImageURL:    []string{"/catalogue/images/weave1.jpg", "/catalogue/images/weave2.jpg"},
+		Price:       17.15,
+		Count:       33,
+		Tags:        []string{"geek", "blue"},
+	},
+	{
+		ID:          "a0a4f044-b040-410d-8ead-4de0446aec7e",
+		Name:        "Nerd leg",
+		Description: "For all those leg lovers out there. A perfect example of a swivel chair trained calf. Meticulously trained on a diet of sitting and Pina Coladas. Phwarr...",
+		ImageURL:    []string{"/catalogue/images/bit_of_leg_1.jpeg", "/catalogue/images/bit_of_leg_2.jpeg"},
+		Price:       7.99,
+		Count:       115,
+		Tags:        []string{"blue", "skin"},
+	},
+	{
+		ID:          "808a2de1-1aaa-4c25-a9b9-6612e8f29a38",
+		Name:        "Crossed",
+		Description: "A mature sock, crossed, with an air of nonchalance.",
+		ImageURL:    []string{"/catalogue/images/cross_1.jpeg", "/catalogue/images/cross_2.jpeg"},
+		Price:       17.32,
+		Count:       738,
+		Tags:        []string{"blue", "red", "action", "formal"},
+	},
+	{
+		ID:          "510a0d7e-8e83-4193-b483-e27e09ddc34d",
+		Name:        "SuperSport XL",
+		Description: "Ready for action. Engineers: be ready to smash that next bug! Be ready, with these super-action-sport-masterpieces. This particular engineer was chased away from the office with a stick.",
+		ImageURL:    []string{"/catalogue/images/puma_1.jpeg", "/catalogue/images/puma_2.jpeg"},
+		Price:       15,
+		Count:       0,
+		Tags:        []string{"sport", "formal", "black"},
+		Variants: []Variant{
+			{SKU: "supersport-xl-m", Attributes: map[string]string{"size": "M"}, Price: 15, Count: 300},
+			{SKU: "supersport-xl-l", Attributes: map[string]string{"size": "L"}, Price: 15, Count: 320},
+			{SKU: "supersport-xl-xl", Attributes: map[string]string{"size": "XL"}, Price: 16.5, Count: 200},
+		},
+	},
+	{
+		ID:          "03fef6ac-1896-4ce8-bd69-b798f85c6e0b",
+		Name:        "Holy",
+		Description: "Socks fit for a Messiah. You too can experience walking in water with these special edition beauties. Each hole is lovingly proggled to leave smooth edges. The only sock approved by a higher power.",
+		ImageURL:    []string{"/catalogue/images/holy_1.jpeg", "/catalogue/images/holy_2.jpeg"},
+		Price:       99.99,
+		Count:       1,
+		Tags:        []string{"action", "magic"},
+	},
+	{
+		ID:          "d3588630-ad8e-49df-bbd7-3167f7efb246",
+		Name:        "YouTube.sock",
+		Description: "We were not paid to sell this sock. It's just a bit geeky.",
+		ImageURL:    []string{"/catalogue/images/youtube_1.jpeg", "/catalogue/images/youtube_2.jpeg"},
+		Price:       10.99,
+		Count:       801,
+		Tags:        []string{"geek", "formal"},
+	},
+	{
+		ID:          "819e1fbf-8b7e-4f6d-811f-693534916a8b",
+		Name:        "Figueroa",
+		Description: "enim officia aliqua excepteur esse deserunt quis aliquip nostrud anim",
+		ImageURL:    []string{"/catalogue/images/WAT.jpg", "/catalogue/images/WAT2.jpg"},
+		Price:       14,
+		Count:       808,
+		Tags:        []string{"brown", "green", "formal"},
+	},
+	{
+		ID:          "zzz4f044-b040-410d-8ead-4de0446aec7e",
+		Name:        "Classic",
+		Description: "Keep it simple.",
+		ImageURL:    []string{"/catalogue/images/classic.jpg", "/catalogue/images/classic2.jpg"},
+		Price:       12,
+		Count:       0,
+		Tags:        []string{"brown", "green"},
+		Variants: []Variant{
+			{SKU: "classic-s-brown", Attributes: map[string]string{"size": "S", "colour": "brown"}, Price: 12, Count: 40},
+			{SKU: "classic-m-brown", Attributes: map[string]string{"size": "M", "colour": "brown"}, Price: 12, Count: 47},
+			{SKU: "classic-m-green", Attributes: map[string]string{"size": "M", "colour": "green"}, Price: 12, Count: 40},
+		},
+	},
+	{
+		ID:          "3395a43e-2d88-40de-b95f-e00e1502085b",
+		Name:        "Colourful",
+		Description: "proident occaecat irure et excepteur labore minim nisi amet irure",
+		ImageURL:    []string{"/catalogue/images/colourful_socks.jpg", "/catalogue/images/colourful_socks.jpg"},
+		Price:       18,
+		Count:       438,
+		Tags:        []string{"brown", "blue"},
+	},
+	{
+		ID:          "837ab141-399e-4c1f-9abc-bace40296bac",
+		Name:        "Cat socks",
+		Description: "consequat amet cupidatat minim laborum tempor elit ex consequat in",
+		ImageURL:    []string{"/catalogue/images/catsocks.jpg", "/catalogue/images/catsocks2.jpg"},
+		Price:       15,
+		Count:       175,
+		Tags:        []string{"brown", "green", "formal"},
+	},
+}
+
+// Seed fills an empty catalogue with the seed socks, and leaves one that
+// has any socks alone. It returns how many socks were added.
+func Seed(repo Repository) (int, error) {
+	count, err := repo.Count(Filter{})
+	if err != nil || count > 0 {
+		return 0, err
+	}
+	for i, sock := range seedSocks {
+		if err := repo.CreateSock(sock); err != nil {
+			return i, err
+		}
+	}
+	return len(seedSocks), nil
+}
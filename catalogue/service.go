@@ -1,35 +1,51 @@
 package catalogue
 
 import (
-	"fmt"
+	"errors"
 	"strings"
 	"time"
 
-	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 )
 
 type Service interface {
-	List(tag []string, order string, pageNum, pageSize int) ([]Sock, error)
-	Count(tags []string) (int, error)
+	List(filter Filter, page PageRequest) (Page, error)
+	Count(filter Filter) (int, error)
 	Get(id string) (Sock, error)
+	Search(query SearchQuery) (SearchResult, error)
 	Tags() ([]string, error)
+	CreateSock(sock Sock) (Sock, error)
+	UpdateSock(sock Sock) (Sock, error)
+	DeleteSock(id string) error
+	CreateTag(name string) error
+	DeleteTag(name string) error
+	TagSock(id string, tag string) (Sock, error)
+	UntagSock(id string, tag string) (Sock, error)
+	SetImage(id string, slot int, url string) (Sock, error)
+	Reserve(items []ReservationItem, ttl time.Duration) (Reservation, error)
+	GetReservation(id string) (Reservation, error)
+	CommitReservation(id string) (Reservation, error)
+	ReleaseReservation(id string) (Reservation, error)
+	ReleaseExpired(now time.Time) (int, error)
 	Health() []Health
 }
 
 type Middleware func(Service) Service
 
 type Sock struct {
-	ID          string   `json:"id" db:"id"`
-	Name        string   `json:"name" db:"name"`
-	Description string   `json:"description" db:"description"`
-	ImageURL    []string `json:"imageUrl db:"-"`
-	ImageURL1   string   `json:"-" db:"image_url_1"`
-	ImageURL2   string   `json:"-" db:"image_url_2"`
-	Price       float32  `json:"price" db:"price"`
-	Count       int      `json:"count" db:"count"`
-	Tags        []string `json:"tag" db:"-"`
-	TagString   string   `json:"-" db:"tag_name"`
+	ID          string    `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	ImageURL    []string  `json:"imageUrl db:"-"`
+	ImageURL1   string    `json:"-" db:"image_url_1"`
+	ImageURL2   string    `json:"-" db:"image_url_2"`
+	Price       float32   `json:"price" db:"price"`
+	Count       int       `json:"count" db:"count"`
+	Tags        []string  `json:"tag" db:"-"`
+	TagString   string    `json:"-" db:"tag_name"`
+	Variants    []Variant `json:"variants" db:"-"`
+	Created     int64     `json:"-" db:"created_at"`
+	Sold        int       `json:"-" db:"sold"`
 }
 type Health struct {
 	Service string `json:"service"`
@@ -38,106 +54,293 @@ type Health struct {
 }
 
 type catalogueService struct {
-	db     *sqlx.DB
+	repo   Repository
+	index  Index
 	logger *zap.Logger
 }
 
-func NewCatalogueService(db *sqlx.DB, logger *zap.Logger) Service {
-	return &catalogueService{db, logger}
+func NewCatalogueService(repo Repository, index Index, logger *zap.Logger) Service {
+	return &catalogueService{repo, index, logger}
 }
 
-func (s catalogueService) List(tags []string, order string, pageNum, pageSize int) ([]Sock, error) {
-	if pageNum == 0 || pageSize == 0 {
-		return []Sock{}, nil
+func (s catalogueService) List(filter Filter, page PageRequest) (Page, error) {
+	if err := filter.Validate(); err != nil {
+		return Page{Socks: []Sock{}}, err
+	}
+	result, err := s.repo.List(filter, page)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidCursor) {
+			s.logger.Error("database error", zap.Error(err))
+		}
+		return result, err
+	}
+	if result.Facets, err = s.repo.Facets(filter); err != nil {
+		s.logger.Error("database error", zap.Error(err))
+	}
+	return result, err
+}
+
+func (s *catalogueService) Count(filter Filter) (int, error) {
+	if err := filter.Validate(); err != nil {
+		return 0, err
+	}
+	count, err := s.repo.Count(filter)
+	if err != nil {
+		s.logger.Error("database error", zap.Error(err))
+		return 0, err
 	}
 
-	query := "SELECT sock.sock_id AS id, sock.name, sock.description, sock.price, sock.count, sock.image_url_1, sock.image_url_2, GROUP_CONCAT(tag.name) AS tag_name FROM sock JOIN sock_tag ON sock.sock_id=sock_tag.sock_id JOIN tag ON sock_tag.tag_id=tag.tag_id"
+	return count, nil
+}
 
-	for i, t := range tags {
-		if i == 0 {
-			query += fmt.Sprintf(" WHERE tag.name=%s", t)
-		} else {
-			query += fmt.Sprintf(" OR tag.name=%s", t)
+func (s *catalogueService) Get(id string) (Sock, error) {
+	sock, err := s.repo.Get(id)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			s.logger.Error("database error", zap.Error(err))
 		}
+		return Sock{}, err
 	}
 
-	query += " GROUP BY id"
+	return sock, nil
+}
 
-	if order != "" {
-		query += fmt.Sprintf(" ORDER BY %s", order)
+func (s *catalogueService) Search(query SearchQuery) (SearchResult, error) {
+	if err := (Filter{Tags: query.Tags, Match: query.Match}).Validate(); err != nil {
+		return SearchResult{Hits: []Hit{}, Facets: map[string]int{}}, err
 	}
+	return s.index.Search(query)
+}
 
-	query += ";"
-
-	socks := []Sock{}
-	if err := s.db.Select(&socks, query); err != nil {
+func (s *catalogueService) CreateSock(sock Sock) (Sock, error) {
+	if err := validateSock(sock); err != nil {
+		return Sock{}, err
+	}
+	if sock.Variants == nil {
+		sock.Variants = []Variant{}
+	}
+	sock.Created = time.Now().Unix()
+	if sock.ID == "" {
+		id, err := newUUID()
+		if err != nil {
+			return Sock{}, err
+		}
+		sock.ID = id
+	}
+	if err := s.repo.CreateSock(sock); err != nil {
 		s.logger.Error("database error", zap.Error(err))
-		return []Sock{}, fmt.Errorf("database connection error %w", err)
+		return Sock{}, err
 	}
+	return s.reindex(sock.ID)
+}
 
-	for i, sock := range socks {
-		socks[i].ImageURL = []string{sock.ImageURL1, sock.ImageURL2}
-		socks[i].Tags = strings.Split(sock.TagString, ",")
+// UpdateSock replaces the details of the sock, and its tags, images and
+// variants when they are given.
+func (s *catalogueService) UpdateSock(sock Sock) (Sock, error) {
+	if err := validateSock(sock); err != nil {
+		return Sock{}, err
 	}
+	if err := s.repo.UpdateSock(sock); err != nil {
+		s.logError(err)
+		return Sock{}, err
+	}
+	return s.reindex(sock.ID)
+}
 
-	time.Sleep(0 * time.Millisecond)
-
-	start := (pageNum * pageSize) - pageSize
-	if start > len(socks) {
-		return []Sock{}, nil
+func (s *catalogueService) DeleteSock(id string) error {
+	if err := s.repo.DeleteSock(id); err != nil {
+		s.logError(err)
+		return err
 	}
+	return s.index.Delete(id)
+}
 
-	end := (pageNum * pageSize)
-	if end > len(socks) {
-		end = len(socks)
+func (s *catalogueService) CreateTag(name string) error {
+	if err := validateTag(name); err != nil {
+		return err
 	}
+	return s.repo.CreateTag(name)
+}
 
-	return socks[start:end], nil
+// DeleteTag removes the tag from every sock it was on.
+func (s *catalogueService) DeleteTag(name string) error {
+	if err := s.repo.DeleteTag(name); err != nil {
+		s.logError(err)
+		return err
+	}
+	return RebuildIndex(s.repo, s.index)
 }
 
-func (s *catalogueService) Count(tags []string) (int, error) {
-	query := "SELECT COUNT(DISTINCT sock.sock_id) FROM sock JOIN sock_tag ON sock.sock_id=sock_tag.sock_id JOIN tag ON sock_tag.tag_id=tag.tag_id"
+func (s *catalogueService) TagSock(id string, tag string) (Sock, error) {
+	if err := validateTag(tag); err != nil {
+		return Sock{}, err
+	}
+	if err := s.repo.TagSock(id, tag); err != nil {
+		s.logError(err)
+		return Sock{}, err
+	}
+	return s.reindex(id)
+}
 
-	for i, t := range tags {
-		if i == 0 {
-			query += fmt.Sprintf(" WHERE tag.name=%s", t)
-		} else {
-			query += fmt.Sprintf(" OR tag.name=%s", t)
-		}
+func (s *catalogueService) UntagSock(id string, tag string) (Sock, error) {
+	if err := s.repo.UntagSock(id, tag); err != nil {
+		s.logError(err)
+		return Sock{}, err
 	}
+	return s.reindex(id)
+}
 
-	query += ";"
+// SetImage points image slot 1 or 2 of the sock at url.
+func (s *catalogueService) SetImage(id string, slot int, url string) (Sock, error) {
+	if slot != 1 && slot != 2 {
+		return Sock{}, ErrInvalidSlot
+	}
+	if err := s.repo.SetImage(id, slot, url); err != nil {
+		s.logError(err)
+		return Sock{}, err
+	}
+	return s.reindex(id)
+}
 
-	var count int
-	err := s.db.QueryRow(query).Scan(&count)
+// Reserve holds stock of the items for ttl, failing without holding any if
+// a sock has too few left.
+func (s *catalogueService) Reserve(items []ReservationItem, ttl time.Duration) (Reservation, error) {
+	items, err := mergeReservationItems(items)
 	if err != nil {
-		s.logger.Error("database error", zap.Error(err))
-		return 0, fmt.Errorf("database connection error %w", err)
+		return Reservation{}, err
 	}
+	id, err := newUUID()
+	if err != nil {
+		return Reservation{}, err
+	}
+	now := time.Now()
+	r := Reservation{
+		ID:        id,
+		Status:    ReservationHeld,
+		Items:     items,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := s.repo.Reserve(r); err != nil {
+		if !errors.Is(err, ErrInsufficientStock) {
+			s.logError(err)
+		}
+		return Reservation{}, err
+	}
+	s.reindexStock(r.Items)
+	return r, nil
+}
 
-	return count, nil
+func (s *catalogueService) GetReservation(id string) (Reservation, error) {
+	return s.repo.Reservation(id)
 }
 
-func (s *catalogueService) Get(id string) (Sock, error) {
-	query := fmt.Sprintf("SELECT sock.sock_id AS id, sock.name, sock.description, sock.price, sock.count, sock.image_url_1, sock.image_url_2, GROUP_CONCAT(tag.name) AS tag_name FROM sock JOIN sock_tag ON sock.sock_id=sock_tag.sock_id JOIN tag ON sock_tag.tag_id=tag.tag_id WHERE sock.sock_id =%s GROUP BY sock.sock_id;", id)
+// CommitReservation makes the stock taken by a held reservation permanent.
+// A reservation found expired is released instead.
+func (s *catalogueService) CommitReservation(id string) (Reservation, error) {
+	return s.closeReservation(id, ReservationCommitted)
+}
 
-	var sock Sock
-	if err := s.db.Get(&sock, query); err != nil {
-		s.logger.Error("database error", zap.Error(err))
-		return Sock{}, fmt.Errorf("not found %w", err)
+// ReleaseReservation gives the stock of a held reservation back. Releasing a
+// reservation that has already been released or expired does nothing.
+func (s *catalogueService) ReleaseReservation(id string) (Reservation, error) {
+	return s.closeReservation(id, ReservationReleased)
+}
+
+func (s *catalogueService) closeReservation(id string, status ReservationStatus) (Reservation, error) {
+	held := false
+	r, err := s.repo.UpdateReservation(id, func(r Reservation) (ReservationStatus, error) {
+		switch r.Status {
+		case ReservationHeld:
+		case ReservationReleased, ReservationExpired:
+			if status == ReservationReleased {
+				return r.Status, nil
+			}
+			return r.Status, ErrReservationClosed
+		default:
+			return r.Status, ErrReservationClosed
+		}
+		held = true
+		if status == ReservationCommitted && !time.Now().Before(r.ExpiresAt) {
+			return ReservationExpired, nil
+		}
+		return status, nil
+	})
+	if err != nil {
+		return Reservation{}, err
+	}
+	if held && r.Status != ReservationCommitted {
+		s.reindexStock(r.Items)
 	}
+	if held && r.Status == ReservationExpired {
+		return r, ErrReservationExpired
+	}
+	return r, nil
+}
 
-	sock.ImageURL = []string{sock.ImageURL1, sock.ImageURL2}
-	sock.Tags = strings.Split(sock.TagString, ",")
+// ReleaseExpired releases the reservations still held after they expired,
+// returning how many were released.
+func (s *catalogueService) ReleaseExpired(now time.Time) (int, error) {
+	ids, err := s.repo.HeldReservations(now)
+	if err != nil {
+		return 0, err
+	}
+	released := 0
+	for _, id := range ids {
+		expired := false
+		r, err := s.repo.UpdateReservation(id, func(r Reservation) (ReservationStatus, error) {
+			// it may have been committed or released since it was listed
+			if r.Status != ReservationHeld {
+				return r.Status, nil
+			}
+			expired = true
+			return ReservationExpired, nil
+		})
+		if err != nil {
+			return released, err
+		}
+		if expired {
+			s.reindexStock(r.Items)
+			released++
+		}
+	}
+	return released, nil
+}
+
+// reindexStock refreshes the socks whose stock changed in the search index.
+func (s *catalogueService) reindexStock(items []ReservationItem) {
+	for _, item := range items {
+		if _, err := s.reindex(item.SockID); err != nil {
+			s.logger.Error("reindex sock", zap.String("id", item.SockID), zap.Error(err))
+		}
+	}
+}
 
+// reindex reads the sock back once it has been written and updates the
+// search index with it.
+func (s *catalogueService) reindex(id string) (Sock, error) {
+	sock, err := s.Get(id)
+	if err != nil {
+		return Sock{}, err
+	}
+	if err := s.index.Put(sock); err != nil {
+		return Sock{}, err
+	}
 	return sock, nil
 }
 
+// logError logs errors of the repository other than a missing sock or tag.
+func (s *catalogueService) logError(err error) {
+	if !errors.Is(err, ErrNotFound) {
+		s.logger.Error("database error", zap.Error(err))
+	}
+}
+
 func (s *catalogueService) Health() []Health {
 	var health []Health
 	dbstatus := "OK"
 
-	err := s.db.Ping()
+	err := s.repo.Ping()
 	if err != nil {
 		dbstatus = "err"
 	}
@@ -151,13 +354,18 @@ func (s *catalogueService) Health() []Health {
 }
 
 func (s *catalogueService) Tags() ([]string, error) {
-	var tags []string
-	query := "SELECT name FROM tag;"
-
-	if err := s.db.Select(&tags, query); err != nil {
+	tags, err := s.repo.Tags()
+	if err != nil {
 		s.logger.Error("database error", zap.Error(err))
-		return []string{}, fmt.Errorf("database connection error %w", err)
+		return []string{}, err
 	}
 
 	return tags, nil
 }
+
+func splitTags(tagString string) []string {
+	if tagString == "" {
+		return []string{}
+	}
+	return strings.Split(tagString, ",")
+}
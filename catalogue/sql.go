@@ -0,0 +1,624 @@
+package catalogue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect holds what differs between the SQL databases the catalogue runs
+// on.
+type dialect struct {
+	// forUpdate locks the rows a SELECT reads until the transaction ends,
+	// empty where the database locks whole transactions instead.
+	forUpdate  string
+	migrations []migration
+}
+
+var mysqlDialect = dialect{
+	forUpdate:  " FOR UPDATE",
+	migrations: mysqlMigrations,
+}
+
+var sqliteDialect = dialect{
+	forUpdate:  "",
+	migrations: sqliteMigrations,
+}
+
+// SQLRepository stores the catalogue in MySQL or SQLite.
+type SQLRepository struct {
+	db      *sqlx.DB
+	dialect dialect
+}
+
+func OpenMySQL(dsn string) (*SQLRepository, error) {
+	db, err := sqlx.Connect("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLRepository{db: db, dialect: mysqlDialect}, nil
+}
+
+// OpenSQLite opens the SQLite database at path. The driver is written in C,
+// so the catalogue must be built with CGO_ENABLED=1 for it to work; built
+// without cgo, opening the database fails.
+func OpenSQLite(path string) (*SQLRepository, error) {
+	db, err := sqlx.Connect("sqlite3", path+"?_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, and a :memory: database lives and
+	// dies with its connection, so share a single one
+	db.SetMaxOpenConns(1)
+	return &SQLRepository{db: db, dialect: sqliteDialect}, nil
+}
+
+// Close closes the database.
+func (r *SQLRepository) Close() error {
+	return r.db.Close()
+}
+
+const selectSocks = "SELECT sock.sock_id AS id, sock.name, sock.description, sock.price, sock.count, sock.image_url_1, sock.image_url_2, sock.created_at, sock.sold, COALESCE(GROUP_CONCAT(tag.name), '') AS tag_name FROM sock LEFT JOIN sock_tag ON sock.sock_id=sock_tag.sock_id LEFT JOIN tag ON sock_tag.tag_id=tag.tag_id"
+
+func (r *SQLRepository) List(filter Filter, page PageRequest) (Page, error) {
+	order, err := filter.sortOrder()
+	if err != nil {
+		return Page{Socks: []Sock{}}, err
+	}
+
+	total, err := r.Count(filter)
+	if err != nil {
+		return Page{Socks: []Sock{}}, err
+	}
+	if page.Size <= 0 || (page.Cursor == "" && page.Num <= 0) {
+		return Page{Socks: []Sock{}, Total: total}, nil
+	}
+
+	q := filterQuery(filter)
+	offset := 0
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor, order)
+		if err != nil {
+			return Page{Socks: []Sock{}, Total: total}, err
+		}
+		order.after(q, c)
+	} else {
+		offset = (page.Num - 1) * page.Size
+	}
+	// one sock more than the page holds tells whether there is a next page
+	query := selectSocks + q.clause() + " GROUP BY sock.sock_id" + order.orderBy() + " LIMIT ? OFFSET ?;"
+	args := append(q.args, page.Size+1, offset)
+
+	socks := []Sock{}
+	if err := r.db.Select(&socks, query, args...); err != nil {
+		return Page{Socks: []Sock{}, Total: total}, fmt.Errorf("database connection error %w", err)
+	}
+	if err := r.expandSocks(socks); err != nil {
+		return Page{Socks: []Sock{}, Total: total}, fmt.Errorf("database connection error %w", err)
+	}
+
+	next := ""
+	if len(socks) > page.Size {
+		socks = socks[:page.Size]
+		next = encodeCursor(order, socks[len(socks)-1])
+	}
+	return Page{Socks: socks, Total: total, Next: next}, nil
+}
+
+func (r *SQLRepository) Count(filter Filter) (int, error) {
+	q := filterQuery(filter)
+	query := "SELECT COUNT(*) FROM sock" + q.clause() + ";"
+
+	var count int
+	if err := r.db.QueryRow(query, q.args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("database connection error %w", err)
+	}
+	return count, nil
+}
+
+func (r *SQLRepository) Facets(filter Filter) (Facets, error) {
+	facets := Facets{Tags: map[string]int{}, Prices: newPriceBuckets()}
+	tagFilter, priceFilter := filter.facetFilters()
+
+	q := filterQuery(tagFilter)
+	tags := []struct {
+		Name  string `db:"name"`
+		Count int    `db:"n"`
+	}{}
+	query := "SELECT tag.name, COUNT(DISTINCT sock_tag.sock_id) AS n FROM sock_tag JOIN tag ON sock_tag.tag_id=tag.tag_id WHERE sock_tag.sock_id IN (SELECT sock.sock_id FROM sock" + q.clause() + ") GROUP BY tag.name;"
+	if err := r.db.Select(&tags, query, q.args...); err != nil {
+		return Facets{}, fmt.Errorf("database connection error %w", err)
+	}
+	for _, t := range tags {
+		facets.Tags[t.Name] = t.Count
+	}
+
+	// a sock counts in every bucket its own price or that of one of its
+	// variants falls in
+	q = filterQuery(priceFilter)
+	sockBucket, sockArgs := priceBucketCase("sock.price")
+	variantBucket, variantArgs := priceBucketCase("variant.price")
+	prices := []struct {
+		Bucket int `db:"bucket"`
+		Count  int `db:"n"`
+	}{}
+	query = "SELECT bucket, COUNT(DISTINCT sock_id) AS n FROM (" +
+		"SELECT sock.sock_id, " + sockBucket + " AS bucket FROM sock" + q.clause() +
+		" UNION ALL SELECT sock.sock_id, " + variantBucket + " AS bucket FROM variant JOIN sock ON variant.sock_id=sock.sock_id" + q.clause() +
+		") AS sock_price GROUP BY bucket;"
+	args := append(append(append(sockArgs, q.args...), variantArgs...), q.args...)
+	if err := r.db.Select(&prices, query, args...); err != nil {
+		return Facets{}, fmt.Errorf("database connection error %w", err)
+	}
+	for _, p := range prices {
+		facets.Prices[p.Bucket].Count = p.Count
+	}
+	return facets, nil
+}
+
+func (r *SQLRepository) Get(id string) (Sock, error) {
+	query := selectSocks + " WHERE sock.sock_id = ? GROUP BY sock.sock_id;"
+
+	var sock Sock
+	if err := r.db.Get(&sock, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Sock{}, ErrNotFound
+		}
+		return Sock{}, err
+	}
+	socks := []Sock{sock}
+	if err := r.expandSocks(socks); err != nil {
+		return Sock{}, err
+	}
+	return socks[0], nil
+}
+
+func (r *SQLRepository) All() ([]Sock, error) {
+	socks := []Sock{}
+	if err := r.db.Select(&socks, selectSocks+" GROUP BY sock.sock_id;"); err != nil {
+		return nil, err
+	}
+	if err := r.expandSocks(socks); err != nil {
+		return nil, err
+	}
+	return socks, nil
+}
+
+func (r *SQLRepository) Tags() ([]string, error) {
+	tags := []string{}
+	if err := r.db.Select(&tags, "SELECT name FROM tag;"); err != nil {
+		return []string{}, fmt.Errorf("database connection error %w", err)
+	}
+	return tags, nil
+}
+
+// expandSocks fills in the images and tags of socks from the columns they
+// are read from, and reads their variants.
+func (r *SQLRepository) expandSocks(socks []Sock) error {
+	if len(socks) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(socks))
+	for i, sock := range socks {
+		socks[i].ImageURL = []string{sock.ImageURL1, sock.ImageURL2}
+		socks[i].Tags = splitTags(sock.TagString)
+		socks[i].Variants = []Variant{}
+		ids[i] = sock.ID
+	}
+
+	variants := []Variant{}
+	if err := r.db.Select(&variants, "SELECT sku, sock_id, price, count FROM variant WHERE sock_id IN ("+placeholders(len(ids))+") ORDER BY sku;", ids...); err != nil {
+		return err
+	}
+	attributes := []struct {
+		SKU   string `db:"sku"`
+		Name  string `db:"name"`
+		Value string `db:"value"`
+	}{}
+	if err := r.db.Select(&attributes, "SELECT variant_attribute.sku, variant_attribute.name, variant_attribute.value FROM variant_attribute JOIN variant ON variant_attribute.sku=variant.sku WHERE variant.sock_id IN ("+placeholders(len(ids))+");", ids...); err != nil {
+		return err
+	}
+	bySKU := map[string]map[string]string{}
+	for _, a := range attributes {
+		if bySKU[a.SKU] == nil {
+			bySKU[a.SKU] = map[string]string{}
+		}
+		bySKU[a.SKU][a.Name] = a.Value
+	}
+	bySock := map[string][]Variant{}
+	for _, v := range variants {
+		v.Attributes = bySKU[v.SKU]
+		if v.Attributes == nil {
+			v.Attributes = map[string]string{}
+		}
+		bySock[v.SockID] = append(bySock[v.SockID], v)
+	}
+	for i, sock := range socks {
+		if v, ok := bySock[sock.ID]; ok {
+			socks[i].Variants = v
+		}
+	}
+	return nil
+}
+
+// withTx runs fn in a transaction, committing it if fn succeeds.
+func (r *SQLRepository) withTx(fn func(tx *sqlx.Tx) error) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLRepository) CreateSock(sock Sock) error {
+	images := append(append([]string(nil), sock.ImageURL...), "", "")
+	return r.withTx(func(tx *sqlx.Tx) error {
+		if _, err := tx.Exec("INSERT INTO sock (sock_id, name, description, price, count, image_url_1, image_url_2, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?);",
+			sock.ID, sock.Name, sock.Description, sock.Price, sock.Count, images[0], images[1], sock.Created); err != nil {
+			if isDuplicateKey(err) {
+				return fmt.Errorf("%w: sock %q already exists", ErrInvalidSock, sock.ID)
+			}
+			return err
+		}
+		if err := r.setSockTags(tx, sock.ID, sock.Tags); err != nil {
+			return err
+		}
+		return setVariants(tx, sock.ID, sock.Variants)
+	})
+}
+
+func (r *SQLRepository) UpdateSock(sock Sock) error {
+	return r.withTx(func(tx *sqlx.Tx) error {
+		if err := r.lockSock(tx, sock.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE sock SET name = ?, description = ?, price = ?, count = ? WHERE sock_id = ?;",
+			sock.Name, sock.Description, sock.Price, sock.Count, sock.ID); err != nil {
+			return err
+		}
+		if sock.ImageURL != nil {
+			images := append(append([]string(nil), sock.ImageURL...), "", "")
+			if _, err := tx.Exec("UPDATE sock SET image_url_1 = ?, image_url_2 = ? WHERE sock_id = ?;", images[0], images[1], sock.ID); err != nil {
+				return err
+			}
+		}
+		if sock.Tags != nil {
+			if err := r.setSockTags(tx, sock.ID, sock.Tags); err != nil {
+				return err
+			}
+		}
+		if sock.Variants != nil {
+			return setVariants(tx, sock.ID, sock.Variants)
+		}
+		return nil
+	})
+}
+
+func (r *SQLRepository) DeleteSock(id string) error {
+	return r.withTx(func(tx *sqlx.Tx) error {
+		if err := r.lockSock(tx, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM sock_tag WHERE sock_id = ?;", id); err != nil {
+			return err
+		}
+		if err := setVariants(tx, id, nil); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM sock WHERE sock_id = ?;", id)
+		return err
+	})
+}
+
+func (r *SQLRepository) CreateTag(name string) error {
+	return r.withTx(func(tx *sqlx.Tx) error {
+		_, err := r.tagID(tx, name)
+		return err
+	})
+}
+
+func (r *SQLRepository) DeleteTag(name string) error {
+	return r.withTx(func(tx *sqlx.Tx) error {
+		var id int64
+		if err := tx.Get(&id, "SELECT tag_id FROM tag WHERE name = ?;", name); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM sock_tag WHERE tag_id = ?;", id); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM tag WHERE tag_id = ?;", id)
+		return err
+	})
+}
+
+func (r *SQLRepository) TagSock(id string, tag string) error {
+	return r.withTx(func(tx *sqlx.Tx) error {
+		if err := r.lockSock(tx, id); err != nil {
+			return err
+		}
+		return r.addSockTag(tx, id, tag)
+	})
+}
+
+func (r *SQLRepository) UntagSock(id string, tag string) error {
+	return r.withTx(func(tx *sqlx.Tx) error {
+		if err := r.lockSock(tx, id); err != nil {
+			return err
+		}
+		result, err := tx.Exec("DELETE FROM sock_tag WHERE sock_id = ? AND tag_id IN (SELECT tag_id FROM tag WHERE name = ?);", id, tag)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (r *SQLRepository) SetImage(id string, slot int, url string) error {
+	column, ok := map[int]string{1: "image_url_1", 2: "image_url_2"}[slot]
+	if !ok {
+		return ErrInvalidSlot
+	}
+	return r.withTx(func(tx *sqlx.Tx) error {
+		if err := r.lockSock(tx, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec("UPDATE sock SET "+column+" = ? WHERE sock_id = ?;", url, id)
+		return err
+	})
+}
+
+// lockSock fails with ErrNotFound unless the sock exists, locking its row
+// for the rest of the transaction.
+func (r *SQLRepository) lockSock(tx *sqlx.Tx, id string) error {
+	var found string
+	if err := tx.Get(&found, "SELECT sock_id FROM sock WHERE sock_id = ?"+r.dialect.forUpdate+";", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// tagID returns the id of the named tag, creating the tag if need be.
+func (r *SQLRepository) tagID(tx *sqlx.Tx, name string) (int64, error) {
+	var id int64
+	err := tx.Get(&id, "SELECT tag_id FROM tag WHERE name = ?;", name)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	result, err := tx.Exec("INSERT INTO tag (name) VALUES (?);", name)
+	if isDuplicateKey(err) {
+		// another transaction created the tag since it was looked up; a
+		// locking read sees it where the first read's snapshot does not
+		err = tx.Get(&id, "SELECT tag_id FROM tag WHERE name = ?"+r.dialect.forUpdate+";", name)
+		return id, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// setSockTags replaces the tags of the sock with tags.
+func (r *SQLRepository) setSockTags(tx *sqlx.Tx, id string, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM sock_tag WHERE sock_id = ?;", id); err != nil {
+		return err
+	}
+	for _, t := range uniqueTags(tags) {
+		if err := r.addSockTag(tx, id, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepository) addSockTag(tx *sqlx.Tx, id string, tag string) error {
+	tid, err := r.tagID(tx, tag)
+	if err != nil {
+		return err
+	}
+	var n int
+	if err := tx.Get(&n, "SELECT COUNT(*) FROM sock_tag WHERE sock_id = ? AND tag_id = ?;", id, tid); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err = tx.Exec("INSERT INTO sock_tag (sock_id, tag_id) VALUES (?, ?);", id, tid)
+	return err
+}
+
+// setVariants replaces the variants of the sock with variants.
+func setVariants(tx *sqlx.Tx, id string, variants []Variant) error {
+	if len(variants) > 0 {
+		skus := make([]interface{}, len(variants))
+		for i, v := range variants {
+			skus[i] = v.SKU
+		}
+		var taken []struct {
+			SKU    string `db:"sku"`
+			SockID string `db:"sock_id"`
+		}
+		if err := tx.Select(&taken, "SELECT sku, sock_id FROM variant WHERE sku IN ("+placeholders(len(skus))+") AND sock_id <> ?;", append(skus, id)...); err != nil {
+			return err
+		}
+		if len(taken) > 0 {
+			return fmt.Errorf("%w: sku %q belongs to sock %q", ErrInvalidVariant, taken[0].SKU, taken[0].SockID)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM variant_attribute WHERE sku IN (SELECT sku FROM variant WHERE sock_id = ?);", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM variant WHERE sock_id = ?;", id); err != nil {
+		return err
+	}
+	for _, v := range variants {
+		if _, err := tx.Exec("INSERT INTO variant (sku, sock_id, price, count) VALUES (?, ?, ?, ?);", v.SKU, id, v.Price, v.Count); err != nil {
+			return err
+		}
+		for _, name := range attributeNames(v.Attributes) {
+			if _, err := tx.Exec("INSERT INTO variant_attribute (sku, name, value) VALUES (?, ?, ?);", v.SKU, name, v.Attributes[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepository) Reserve(reservation Reservation) error {
+	return r.withTx(func(tx *sqlx.Tx) error {
+		for _, item := range reservation.Items {
+			var count int
+			if item.SKU != "" {
+				if err := tx.Get(&count, "SELECT count FROM variant WHERE sku = ? AND sock_id = ?"+r.dialect.forUpdate+";", item.SKU, item.SockID); err != nil {
+					if errors.Is(err, sql.ErrNoRows) {
+						return fmt.Errorf("%w: sku %q of sock %q", ErrNotFound, item.SKU, item.SockID)
+					}
+					return err
+				}
+				if count < item.Quantity {
+					return fmt.Errorf("%w: sku %q has %d left", ErrInsufficientStock, item.SKU, count)
+				}
+				if _, err := tx.Exec("UPDATE variant SET count = count - ? WHERE sku = ?;", item.Quantity, item.SKU); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Get(&count, "SELECT count FROM sock WHERE sock_id = ?"+r.dialect.forUpdate+";", item.SockID); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return fmt.Errorf("%w: sock %q", ErrNotFound, item.SockID)
+				}
+				return err
+			}
+			if count < item.Quantity {
+				return fmt.Errorf("%w: sock %q has %d left", ErrInsufficientStock, item.SockID, count)
+			}
+			if _, err := tx.Exec("UPDATE sock SET count = count - ? WHERE sock_id = ?;", item.Quantity, item.SockID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec("INSERT INTO reservation (reservation_id, status, created_at, expires_at) VALUES (?, ?, ?, ?);",
+			reservation.ID, reservation.Status, reservation.CreatedAt.Unix(), reservation.ExpiresAt.Unix()); err != nil {
+			return err
+		}
+		for _, item := range reservation.Items {
+			if _, err := tx.Exec("INSERT INTO reservation_item (reservation_id, sock_id, sku, quantity) VALUES (?, ?, ?, ?);", reservation.ID, item.SockID, item.SKU, item.Quantity); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *SQLRepository) Reservation(id string) (Reservation, error) {
+	var reservation Reservation
+	err := r.withTx(func(tx *sqlx.Tx) error {
+		var err error
+		reservation, err = r.lockReservation(tx, id)
+		return err
+	})
+	return reservation, err
+}
+
+func (r *SQLRepository) UpdateReservation(id string, decide func(r Reservation) (ReservationStatus, error)) (Reservation, error) {
+	var reservation Reservation
+	err := r.withTx(func(tx *sqlx.Tx) error {
+		var err error
+		reservation, err = r.lockReservation(tx, id)
+		if err != nil {
+			return err
+		}
+		status, err := decide(reservation)
+		if err != nil || status == reservation.Status {
+			return err
+		}
+		if reservation.Status == ReservationHeld {
+			// stock is given back unless it is sold, which is counted
+			// against the sock whichever variant it was
+			for _, item := range reservation.Items {
+				query, key := "UPDATE sock SET count = count + ? WHERE sock_id = ?;", item.SockID
+				switch {
+				case status == ReservationCommitted:
+					query = "UPDATE sock SET sold = sold + ? WHERE sock_id = ?;"
+				case item.SKU != "":
+					query, key = "UPDATE variant SET count = count + ? WHERE sku = ?;", item.SKU
+				}
+				if _, err := tx.Exec(query, item.Quantity, key); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := tx.Exec("UPDATE reservation SET status = ? WHERE reservation_id = ?;", status, id); err != nil {
+			return err
+		}
+		reservation.Status = status
+		return nil
+	})
+	if err != nil {
+		return Reservation{}, err
+	}
+	return reservation, nil
+}
+
+// lockReservation reads the reservation, locking it for the rest of the
+// transaction.
+func (r *SQLRepository) lockReservation(tx *sqlx.Tx, id string) (Reservation, error) {
+	var reservation Reservation
+	if err := tx.Get(&reservation, "SELECT reservation_id, status, created_at, expires_at FROM reservation WHERE reservation_id = ?"+r.dialect.forUpdate+";", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Reservation{}, ErrNotFound
+		}
+		return Reservation{}, err
+	}
+	if err := tx.Select(&reservation.Items, "SELECT sock_id, sku, quantity FROM reservation_item WHERE reservation_id = ? ORDER BY sock_id, sku;", id); err != nil {
+		return Reservation{}, err
+	}
+	reservation.CreatedAt = time.Unix(reservation.Created, 0)
+	reservation.ExpiresAt = time.Unix(reservation.Expires, 0)
+	return reservation, nil
+}
+
+func (r *SQLRepository) HeldReservations(before time.Time) ([]string, error) {
+	ids := []string{}
+	if err := r.db.Select(&ids, "SELECT reservation_id FROM reservation WHERE status = ? AND expires_at <= ?;", ReservationHeld, before.Unix()); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *SQLRepository) Ping() error {
+	return r.db.Ping()
+}
+
+// isDuplicateKey reports whether err is the database refusing a row whose
+// key is already taken.
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	return false
+}
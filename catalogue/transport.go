@@ -1,47 +1,105 @@
 package catalogue
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 )
 
-func MakeHTTPHandler(service Service, imagePath string) *fiber.App {
+// MakeHTTPHandler serves the catalogue, along with the admin API when an
+// admin token is given and the inventory API when an inventory token is.
+func MakeHTTPHandler(service Service, imagePath string, imageCache string, adminToken string, inventoryToken string) *fiber.App {
 	app := fiber.New()
 	catalogue := app.Group("/catalogue")
 	catalogue.Get("/", list(service))
 	catalogue.Get("/size", size(service))
+	catalogue.Get("/search", search(service))
+	catalogue.Get("/images/:name", serveImage(imagePath, imageCache))
 	catalogue.Get("/:id", id(service))
 	app.Get("/tags", tags(service))
 	app.Get("/health", health(service))
+	if inventoryToken != "" {
+		reservations := app.Group("/inventory/reservations", RequireToken(inventoryToken))
+		reservations.Post("/", reserve(service))
+		reservations.Get("/:id", getReservation(service))
+		reservations.Post("/:id/commit", commitReservation(service))
+		reservations.Delete("/:id", releaseReservation(service))
+	}
+	if adminToken != "" {
+		admin := app.Group("/admin", RequireToken(adminToken))
+		admin.Post("/socks", createSock(service))
+		admin.Put("/socks/:id", updateSock(service))
+		admin.Delete("/socks/:id", deleteSock(service))
+		admin.Post("/socks/:id/images", uploadImage(service, imagePath))
+		admin.Put("/socks/:id/tags/:tag", tagSock(service))
+		admin.Delete("/socks/:id/tags/:tag", untagSock(service))
+		admin.Post("/tags", createTag(service))
+		admin.Delete("/tags/:tag", deleteTag(service))
+	}
 	return app
 }
 
 func list(service Service) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		_ = c.Context()
-		req, _ := decodeListRequest(c)
-		socks, err := service.List(req.Tags, req.Order, req.PageNum, req.PageSize)
-		return c.JSON(listResponse{socks, err})
+		req, err := decodeListRequest(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		page, err := service.List(req.Filter, req.Page)
+		if errors.Is(err, ErrInvalidCursor) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		return c.JSON(listResponse{page.Socks, page.Total, page.Next, page.Facets, err})
 	}
 }
 
 func size(service Service) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		_ = c.Context()
-		req, _ := decodeCountRequest(c)
-		n, err := service.Count(req.Tags)
+		req, err := decodeCountRequest(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		n, err := service.Count(req.Filter)
 		return c.JSON(countResponse{n, err})
 	}
 }
 
+func search(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		req, err := decodeSearchRequest(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		result, err := service.Search(req.Query)
+		return c.JSON(searchResponse{result.Hits, result.Total, searchFacets{result.Facets}, err})
+	}
+}
+
 func id(service Service) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
-		ctx := c.Context()
-		id, ok := ctx.Value("id").(string)
-		if !ok {
+		_ = c.Context()
+		id := c.Params("id")
+		if id == "" {
 			c.Context().NotFound()
 			return nil
 		}
 		sock, err := service.Get(id)
+		// the body is the same either way, so a missing sock is told apart
+		// from a failure by its status
+		if errors.Is(err, ErrNotFound) {
+			c.Status(fiber.StatusNotFound)
+		}
 		return c.JSON(getResponse{sock, err})
 	}
 }
@@ -61,3 +119,234 @@ func health(service Service) func(c *fiber.Ctx) error {
 		return c.JSON(healthResponse{health})
 	}
 }
+
+func createSock(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		req, err := decodeSockRequest(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		sock, err := service.CreateSock(req.sock())
+		if err != nil {
+			return httpError(err)
+		}
+		return c.Status(fiber.StatusCreated).JSON(sock)
+	}
+}
+
+func updateSock(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		req, err := decodeSockRequest(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		sock := req.sock()
+		sock.ID = c.Params("id")
+		sock, err = service.UpdateSock(sock)
+		if err != nil {
+			return httpError(err)
+		}
+		return c.JSON(sock)
+	}
+}
+
+func deleteSock(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		if err := service.DeleteSock(c.Params("id")); err != nil {
+			return httpError(err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// uploadImage stores the uploaded image in the image directory and points
+// the sock at it. The image is written to a temporary file that only takes
+// the place of the image of the slot once the sock points at it, so a failed
+// upload leaves the image there was alone.
+func uploadImage(service Service, imagePath string) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		id := c.Params("id")
+		if !sockID.MatchString(id) {
+			return httpError(ErrNotFound)
+		}
+		slot := 1
+		if s := c.FormValue("slot"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, ErrInvalidSlot.Error())
+			}
+			slot = n
+		}
+		if slot != 1 && slot != 2 {
+			return fiber.NewError(fiber.StatusBadRequest, ErrInvalidSlot.Error())
+		}
+		file, err := c.FormFile("image")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "image is required")
+		}
+		ext := strings.ToLower(filepath.Ext(file.Filename))
+		if !imageExtensions[ext] {
+			return fiber.NewError(fiber.StatusUnsupportedMediaType, "image must be a jpeg, png or gif")
+		}
+		if _, err := service.Get(id); err != nil {
+			return httpError(err)
+		}
+
+		name := fmt.Sprintf("%s-%d%s", id, slot, ext)
+		// the temporary name starts with a dot, which is never served
+		tmp, err := ioutil.TempFile(imagePath, ".upload-*")
+		if err != nil {
+			return err
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		if err := c.SaveFile(file, tmp.Name()); err != nil {
+			return err
+		}
+		sock, err := service.SetImage(id, slot, "/catalogue/images/"+name)
+		if err != nil {
+			return httpError(err)
+		}
+		if err := os.Rename(tmp.Name(), filepath.Join(imagePath, name)); err != nil {
+			return err
+		}
+		return c.JSON(sock)
+	}
+}
+
+var imageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+func tagSock(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		sock, err := service.TagSock(c.Params("id"), c.Params("tag"))
+		if err != nil {
+			return httpError(err)
+		}
+		return c.JSON(sock)
+	}
+}
+
+func untagSock(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		sock, err := service.UntagSock(c.Params("id"), c.Params("tag"))
+		if err != nil {
+			return httpError(err)
+		}
+		return c.JSON(sock)
+	}
+}
+
+func createTag(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		req := tagRequest{}
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if err := service.CreateTag(req.Name); err != nil {
+			return httpError(err)
+		}
+		return c.Status(fiber.StatusCreated).JSON(req)
+	}
+}
+
+func deleteTag(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		if err := service.DeleteTag(c.Params("tag")); err != nil {
+			return httpError(err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func reserve(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		req := reserveRequest{}
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		ttl := defaultReservationTTL
+		if req.TTL != 0 {
+			ttl = time.Duration(req.TTL) * time.Second
+		}
+		if ttl <= 0 || ttl > maxReservationTTL {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("ttl must be between 1 and %d seconds", int(maxReservationTTL.Seconds())))
+		}
+		r, err := service.Reserve(req.Items, ttl)
+		if err != nil {
+			return httpError(err)
+		}
+		return c.Status(fiber.StatusCreated).JSON(r)
+	}
+}
+
+func getReservation(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		r, err := service.GetReservation(c.Params("id"))
+		if err != nil {
+			return httpError(err)
+		}
+		return c.JSON(r)
+	}
+}
+
+func commitReservation(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		r, err := service.CommitReservation(c.Params("id"))
+		if err != nil {
+			return httpError(err)
+		}
+		return c.JSON(r)
+	}
+}
+
+func releaseReservation(service Service) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		r, err := service.ReleaseReservation(c.Params("id"))
+		if err != nil {
+			return httpError(err)
+		}
+		return c.JSON(r)
+	}
+}
+
+// CacheStatsHandler serves the counters of the cache.
+func CacheStatsHandler(cache *Cache) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		_ = c.Context()
+		return c.JSON(cache.Stats())
+	}
+}
+
+// httpError maps err to the status the admin and inventory APIs report it
+// with.
+func httpError(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidSock), errors.Is(err, ErrInvalidTag), errors.Is(err, ErrInvalidSlot), errors.Is(err, ErrInvalidVariant),
+		errors.Is(err, ErrInvalidReservation):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrInsufficientStock), errors.Is(err, ErrReservationClosed):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, ErrReservationExpired):
+		return fiber.NewError(fiber.StatusGone, err.Error())
+	}
+	return err
+}
@@ -1,19 +1,22 @@
 package catalogue
 
+import "time"
+
 type listRequest struct {
-	Tags     []string `json:"tags"`
-	Order    string   `json:"order"`
-	PageNum  int      `json:"pageNum"`
-	PageSize int      `json:"pageSize"`
+	Filter Filter      `json:"filter"`
+	Page   PageRequest `json:"page"`
 }
 
 type listResponse struct {
-	Socks []Sock `json:"sock"`
-	Err   error  `json:"err"`
+	Socks  []Sock `json:"sock"`
+	Total  int    `json:"total"`
+	Next   string `json:"next,omitempty"`
+	Facets Facets `json:"facets"`
+	Err    error  `json:"err"`
 }
 
 type countRequest struct {
-	Tags []string `json:"tags"`
+	Filter Filter `json:"filter"`
 }
 
 type countResponse struct {
@@ -21,6 +24,60 @@ type countResponse struct {
 	Err error `json:"err"`
 }
 
+type searchRequest struct {
+	Query SearchQuery `json:"query"`
+}
+
+type searchResponse struct {
+	Hits   []Hit        `json:"sock"`
+	Total  int          `json:"total"`
+	Facets searchFacets `json:"facets"`
+	Err    error        `json:"err"`
+}
+
+type searchFacets struct {
+	Tags map[string]int `json:"tags"`
+}
+
+type sockRequest struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	ImageURL    []string  `json:"imageUrl"`
+	Price       float32   `json:"price"`
+	Count       int       `json:"count"`
+	Tags        []string  `json:"tag"`
+	Variants    []Variant `json:"variants"`
+}
+
+func (r sockRequest) sock() Sock {
+	return Sock{
+		ID:          r.ID,
+		Name:        r.Name,
+		Description: r.Description,
+		ImageURL:    r.ImageURL,
+		Price:       r.Price,
+		Count:       r.Count,
+		Tags:        r.Tags,
+		Variants:    r.Variants,
+	}
+}
+
+type tagRequest struct {
+	Name string `json:"name"`
+}
+
+const (
+	defaultReservationTTL = 10 * time.Minute
+	maxReservationTTL     = time.Hour
+)
+
+type reserveRequest struct {
+	Items []ReservationItem `json:"items"`
+	// TTL is how many seconds the stock is held for.
+	TTL int `json:"ttl"`
+}
+
 type getRequest struct {
 	ID string `json:"id"`
 }
@@ -0,0 +1,106 @@
+package catalogue
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidVariant   = errors.New("invalid variant")
+	ErrInvalidAttribute = errors.New("invalid attribute")
+)
+
+// Variant is a version of a sock sold on its own, such as one size of it,
+// with a price and stock of its own.
+type Variant struct {
+	SKU        string            `json:"sku" db:"sku"`
+	SockID     string            `json:"-" db:"sock_id"`
+	Attributes map[string]string `json:"attributes" db:"-"`
+	Price      float32           `json:"price" db:"price"`
+	Count      int               `json:"count" db:"count"`
+}
+
+// validateVariants checks the variants of a sock sent to the admin API
+// before they are written.
+func validateVariants(variants []Variant) error {
+	skus := map[string]bool{}
+	for _, v := range variants {
+		switch {
+		case v.SKU == "" || strings.TrimSpace(v.SKU) != v.SKU:
+			return fmt.Errorf("%w: sku %q", ErrInvalidVariant, v.SKU)
+		case skus[v.SKU]:
+			return fmt.Errorf("%w: duplicate sku %q", ErrInvalidVariant, v.SKU)
+		case v.Price < 0:
+			return fmt.Errorf("%w: price of %q must not be negative", ErrInvalidVariant, v.SKU)
+		case v.Count < 0:
+			return fmt.Errorf("%w: count of %q must not be negative", ErrInvalidVariant, v.SKU)
+		}
+		skus[v.SKU] = true
+		for name, value := range v.Attributes {
+			if err := validateAttribute(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateAttribute checks an attribute can be written in a filter, as
+// name:value in a comma separated list.
+func validateAttribute(name string, value string) error {
+	for _, s := range []string{name, value} {
+		if s == "" || strings.TrimSpace(s) != s || strings.ContainsAny(s, ",:") {
+			return fmt.Errorf("%w %q", ErrInvalidAttribute, name+":"+value)
+		}
+	}
+	return nil
+}
+
+// parseAttributes parses a comma separated list of name:value attributes.
+func parseAttributes(s string) (map[string]string, error) {
+	attributes := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("%w %q", ErrInvalidAttribute, pair)
+		}
+		attributes[pair[:i]] = pair[i+1:]
+	}
+	return attributes, nil
+}
+
+// attributeNames returns the names of the attributes in order, so that
+// queries over them are built the same way every time.
+func attributeNames(attributes map[string]string) []string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hasAttributes reports whether the variant has every one of the attributes.
+func (v Variant) hasAttributes(attributes map[string]string) bool {
+	for name, value := range attributes {
+		if v.Attributes[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func copyVariants(variants []Variant) []Variant {
+	copied := make([]Variant, len(variants))
+	for i, v := range variants {
+		attributes := make(map[string]string, len(v.Attributes))
+		for name, value := range v.Attributes {
+			attributes[name] = value
+		}
+		v.Attributes = attributes
+		copied[i] = v
+	}
+	return copied
+}
@@ -14,8 +14,11 @@ import (
 )
 
 var (
-	zip  string
-	port string
+	zip       string
+	port      string
+	payment   string
+	catalogue string
+	token     string
 )
 
 const (
@@ -25,11 +28,19 @@ const (
 func main() {
 	flag.StringVar(&zip, "zipkin", os.Getenv("ZIPKIN"), "Zipkin address")
 	flag.StringVar(&port, "port", "8084", "Port on which to run")
+	flag.StringVar(&payment, "payment", "http://payment", "Payment service base URL")
+	flag.StringVar(&catalogue, "catalogue", os.Getenv("CATALOGUE_URL"), "Catalogue service base URL to reserve stock in, empty to not reserve stock")
+	flag.StringVar(&token, "catalogue-token", os.Getenv("CATALOGUE_INVENTORY_TOKEN"), "Bearer token of the catalogue inventory API")
 
 	flag.Parse()
 
 	logger := zap.L()
 
+	// the catalogue serves its inventory API only to holders of its token
+	if catalogue != "" && token == "" {
+		logger.Fatal("a catalogue token is required to reserve stock", zap.String("catalogue", catalogue))
+	}
+
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
 		logger.Fatal("", zap.Error(err))
@@ -51,7 +62,7 @@ func main() {
 	}
 
 	service := order.NewService(logger)
-	router := order.MakeHTTPHandler(service)
+	router := order.MakeHTTPHandler(service, payment, catalogue, token, logger)
 
 	// TODO: httpMiddleware
 	// TODO: handler
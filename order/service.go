@@ -40,13 +40,3 @@ func (s *service) Ping(ctx context.Context) []HealthCheck {
 
 	return []HealthCheck{app, database}
 }
-
-func calculateTotal(items *[]Item) float64 {
-	amount := 0.0
-	for _, item := range *items {
-		amount += float64(item.Quantity) * item.UnitPrice
-	}
-	shipping := 4.99
-	amount += shipping
-	return amount
-}
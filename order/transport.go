@@ -2,21 +2,30 @@ package order
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
 )
 
-func MakeHTTPHandler(service Service) *fiber.App {
+// MakeHTTPHandler serves orders, paid for with the payment service at
+// paymentURL. Stock of the ordered socks is held in the catalogue at
+// catalogueURL while the order is paid for, unless it is empty,
+// authenticating with catalogueToken.
+func MakeHTTPHandler(service Service, paymentURL string, catalogueURL string, catalogueToken string, logger *zap.Logger) *fiber.App {
 	app := fiber.New()
-	app.Post("/orders", orders(service))
+	pay := payment{url: paymentURL}
+	stock := inventory{url: catalogueURL, token: catalogueToken}
+	app.Post("/orders", orders(service, pay, stock, logger))
 	app.Get("/health", health(service))
 	return app
 }
 
-func orders(service Service) func(c *fiber.Ctx) error {
+func orders(service Service, pay payment, stock inventory, logger *zap.Logger) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		ctx := c.Context()
 
@@ -28,23 +37,59 @@ func orders(service Service) func(c *fiber.Ctx) error {
 		if newOrderResource.Address == "" ||
 			newOrderResource.Customer == "" ||
 			newOrderResource.Card == "" ||
-			newOrderResource.Items == "" {
+			(newOrderResource.Items == "" && newOrderResource.Checkout == "") {
 			return fiber.ErrBadRequest
 		}
 
-		itemsRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, newOrderResource.Items, nil)
-		if err != nil {
-			return err
-		}
-		itemsResponse, err := http.DefaultClient.Do(itemsRequest)
-		if err != nil {
-			return err
-		}
-		defer itemsResponse.Body.Close()
-
 		items := new([]Item)
-		if err := json.NewDecoder(itemsResponse.Body).Decode(items); err != nil {
-			return err
+		summary := new(Summary)
+		consumed := false
+		if newOrderResource.Checkout != "" {
+			checkout, err := getCheckout(ctx, newOrderResource.Checkout)
+			if err != nil {
+				return err
+			}
+			*items = checkout.Items
+			*summary = checkout.Summary
+			defer func() {
+				// let the customer change the cart again unless it was ordered
+				if !consumed {
+					if err := closeCheckout(context.Background(), http.MethodDelete, newOrderResource.Checkout); err != nil {
+						logger.Error("release checkout", zap.String("checkout", newOrderResource.Checkout), zap.Error(err))
+					}
+				}
+			}()
+		} else {
+			itemsRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, newOrderResource.Items, nil)
+			if err != nil {
+				return err
+			}
+			itemsResponse, err := http.DefaultClient.Do(itemsRequest)
+			if err != nil {
+				return err
+			}
+			defer itemsResponse.Body.Close()
+			if err := json.NewDecoder(itemsResponse.Body).Decode(items); err != nil {
+				return err
+			}
+
+			summaryRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, cartURL(newOrderResource.Items, "/summary"), nil)
+			if err != nil {
+				return err
+			}
+			summaryResponse, err := http.DefaultClient.Do(summaryRequest)
+			if err != nil {
+				return err
+			}
+			defer summaryResponse.Body.Close()
+			// errors come back as an envelope that would decode into a zero
+			// summary, and a free order
+			if summaryResponse.StatusCode != http.StatusOK {
+				return fiber.NewError(fiber.StatusBadGateway, "cart summary unavailable")
+			}
+			if err := json.NewDecoder(summaryResponse.Body).Decode(summary); err != nil {
+				return err
+			}
 		}
 
 		addressRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, newOrderResource.Address, nil)
@@ -83,34 +128,46 @@ func orders(service Service) func(c *fiber.Ctx) error {
 		card := new(Card)
 		json.NewDecoder(cardResponse.Body).Decode(card)
 
-		amount := calculateTotal(items)
-		paymentRequestBody := PaymentRequest{
+		reservation := ""
+		if stock.url != "" {
+			reservation, err = stock.reserve(ctx, *items)
+			if err != nil {
+				return err
+			}
+		}
+		committed := false
+		defer func() {
+			// give the stock back unless the order went through
+			if reservation != "" && !committed {
+				if err := stock.release(context.Background(), reservation); err != nil {
+					logger.Error("release stock", zap.String("reservation", reservation), zap.Error(err))
+				}
+			}
+		}()
+
+		amount := summary.Total
+		authorisation, err := pay.authorise(ctx, PaymentRequest{
 			Address:  *address,
 			Customer: *customer,
 			Card:     *card,
 			Amount:   amount,
-		}
-		b, err := json.Marshal(paymentRequestBody)
-		if err != nil {
-			return err
-		}
-		paymentRequest, err := http.NewRequest(http.MethodPost, "", bytes.NewReader(b))
-		if err != nil {
-			return err
-		}
-		paymentResponse, err := http.DefaultClient.Do(paymentRequest)
+		})
 		if err != nil {
 			return err
 		}
-		defer paymentResponse.Body.Close()
-		paymentResponseBody := new(PaymentResponse)
-		if err := json.NewDecoder(paymentResponse.Body).Decode(paymentResponseBody); err != nil {
-			return err
-		}
-
-		if !paymentResponseBody.Authorised {
+		if !authorisation.Authorised {
 			return fiber.ErrUnauthorized
 		}
+		saved := false
+		defer func() {
+			// an order that fails once its payment is authorised is not
+			// charged for it
+			if !saved {
+				if err := pay.void(context.Background(), authorisation.ID); err != nil {
+					logger.Error("void payment", zap.String("authorisation", authorisation.ID), zap.Error(err))
+				}
+			}
+		}()
 
 		shipment := Shipment{ID: customer.ID}
 		customerOrder := CustomerOrder{
@@ -125,14 +182,232 @@ func orders(service Service) func(c *fiber.Ctx) error {
 			Total:      amount,
 		}
 
+		// the stock is committed before the checkout is consumed or the
+		// coupon redeemed, which cannot be undone, so that a reservation
+		// that expired fails the order with the cart and coupon intact
+		if reservation != "" {
+			if err := stock.commit(ctx, reservation); err != nil {
+				return err
+			}
+			committed = true
+		}
+
+		if newOrderResource.Checkout != "" {
+			// consuming the snapshot redeems its coupon and empties the cart
+			if err := closeCheckout(ctx, http.MethodPost, newOrderResource.Checkout+"/consume"); err != nil {
+				if committed {
+					logger.Error("stock committed for an order whose checkout was not consumed", zap.String("reservation", reservation), zap.Error(err))
+				}
+				return err
+			}
+			consumed = true
+		} else if summary.Coupon != "" {
+			if err := redeemCoupon(ctx, cartURL(newOrderResource.Items, "/promotion/redeem")); err != nil {
+				if committed {
+					logger.Error("stock committed for an order whose coupon was not redeemed", zap.String("reservation", reservation), zap.Error(err))
+				}
+				return err
+			}
+		}
+
 		if err := db.CreateOrder(ctx, customerOrder); err != nil {
+			if reservation != "" {
+				logger.Error("stock committed for an order that was not saved", zap.String("reservation", reservation), zap.Error(err))
+			}
 			return err
 		}
+		saved = true
+		if err := pay.capture(ctx, authorisation.ID); err != nil {
+			logger.Error("capture payment", zap.String("authorisation", authorisation.ID), zap.Error(err))
+		}
 
 		return c.JSON(customerOrder)
 	}
 }
 
+// payment is the payment service at url.
+type payment struct {
+	url string
+}
+
+func (p payment) authorise(ctx context.Context, paymentRequest PaymentRequest) (*PaymentResponse, error) {
+	b, err := json.Marshal(paymentRequest)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url+"/paymentauth", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body := struct {
+		Authorisation PaymentResponse
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return &body.Authorisation, nil
+}
+
+// void cancels the authorised payment, so that it is not taken.
+func (p payment) void(ctx context.Context, id string) error {
+	return p.settle(ctx, http.MethodDelete, "/paymentauth/"+id)
+}
+
+// capture takes the authorised payment of a saved order.
+func (p payment) capture(ctx context.Context, id string) error {
+	return p.settle(ctx, http.MethodPost, "/paymentauth/"+id+"/capture")
+}
+
+func (p payment) settle(ctx context.Context, method string, path string) error {
+	request, err := http.NewRequestWithContext(ctx, method, p.url+path, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fiber.NewError(fiber.StatusConflict, "payment could not be settled")
+	}
+	return nil
+}
+
+// inventory is the inventory API of the catalogue at url.
+type inventory struct {
+	url   string
+	token string
+}
+
+func (i inventory) request(ctx context.Context, method string, path string, body []byte) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, i.url+"/inventory/reservations"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	request.Header.Set(fiber.HeaderAuthorization, "Bearer "+i.token)
+	return http.DefaultClient.Do(request)
+}
+
+// reserve holds stock of the items in the catalogue, of the variant an
+// item has a SKU of, returning the id of the reservation.
+func (i inventory) reserve(ctx context.Context, items []Item) (string, error) {
+	reservation := Reservation{Items: make([]ReservationItem, 0, len(items))}
+	for _, item := range items {
+		reservation.Items = append(reservation.Items, ReservationItem{ID: item.ItemID, SKU: item.SKU, Quantity: item.Quantity})
+	}
+	b, err := json.Marshal(reservation)
+	if err != nil {
+		return "", err
+	}
+	response, err := i.request(ctx, http.MethodPost, "/", b)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		return "", fiber.NewError(fiber.StatusConflict, "stock could not be reserved")
+	}
+	if err := json.NewDecoder(response.Body).Decode(&reservation); err != nil {
+		return "", err
+	}
+	return reservation.ID, nil
+}
+
+func (i inventory) commit(ctx context.Context, reservation string) error {
+	return i.close(ctx, http.MethodPost, "/"+reservation+"/commit")
+}
+
+func (i inventory) release(ctx context.Context, reservation string) error {
+	return i.close(ctx, http.MethodDelete, "/"+reservation)
+}
+
+func (i inventory) close(ctx context.Context, method string, path string) error {
+	response, err := i.request(ctx, method, path, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fiber.NewError(fiber.StatusConflict, "stock reservation could not be closed")
+	}
+	return nil
+}
+
+// getCheckout fetches the cart checkout snapshot, which must still be open.
+func getCheckout(ctx context.Context, url string) (*Checkout, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fiber.NewError(fiber.StatusConflict, "checkout unavailable")
+	}
+	checkout := new(Checkout)
+	if err := json.NewDecoder(response.Body).Decode(checkout); err != nil {
+		return nil, err
+	}
+	if checkout.Status != "open" {
+		return nil, fiber.NewError(fiber.StatusConflict, "checkout "+checkout.Status)
+	}
+	return checkout, nil
+}
+
+func closeCheckout(ctx context.Context, method string, url string) error {
+	request, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fiber.NewError(fiber.StatusConflict, "checkout could not be closed")
+	}
+	return nil
+}
+
+// redeemCoupon counts the coupon of the cart against the customer's usage
+// limit, failing if the limit has been reached.
+func redeemCoupon(ctx context.Context, url string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
+		return fiber.NewError(fiber.StatusConflict, "coupon could not be redeemed")
+	}
+	return nil
+}
+
+// cartURL derives a resource of the cart from the cart items resource, so
+// that the order is charged what the cart priced.
+func cartURL(items string, resource string) string {
+	cart := strings.TrimSuffix(strings.TrimSuffix(items, "/"), "/items")
+	return cart + resource
+}
+
 func health(service Service) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		ctx := c.Context()
@@ -0,0 +1,107 @@
+package order
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// services stands in for the cart, user, payment and catalogue services,
+// recording the requests that change anything.
+type services struct {
+	commit  int
+	consume int
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (s *services) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	call := r.Method + " " + r.URL.Path
+	status, body := http.StatusOK, interface{}(struct{}{})
+	switch call {
+	case "GET /checkout":
+		body = Checkout{ID: "checkout", Items: []Item{{ItemID: "sock", Quantity: 1}}, Summary: Summary{Coupon: "SAVE", Total: 9}, Status: "open"}
+	case "GET /address", "GET /customer", "GET /card":
+	case "POST /paymentauth":
+		body = map[string]PaymentResponse{"authorisation": {ID: "authorisation", Authorised: true}}
+	case "POST /inventory/reservations/":
+		status, body = http.StatusCreated, Reservation{ID: "reservation"}
+	case "POST /inventory/reservations/reservation/commit":
+		status = s.commit
+	case "POST /checkout/consume":
+		status = s.consume
+	}
+	if r.Method != http.MethodGet && call != "POST /paymentauth" && call != "POST /inventory/reservations/" {
+		s.mu.Lock()
+		s.calls = append(s.calls, call)
+		s.mu.Unlock()
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestOrderFailsWithoutTakingTheCart(t *testing.T) {
+	tests := []struct {
+		name    string
+		commit  int
+		consume int
+		calls   []string
+	}{
+		{
+			name:    "commit fails",
+			commit:  http.StatusConflict,
+			consume: http.StatusOK,
+			calls: []string{
+				"POST /inventory/reservations/reservation/commit",
+				"DELETE /paymentauth/authorisation",
+				"DELETE /inventory/reservations/reservation",
+				"DELETE /checkout",
+			},
+		},
+		{
+			name:    "consume fails",
+			commit:  http.StatusOK,
+			consume: http.StatusConflict,
+			calls: []string{
+				"POST /inventory/reservations/reservation/commit",
+				"POST /checkout/consume",
+				"DELETE /paymentauth/authorisation",
+				"DELETE /checkout",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := &services{commit: tt.commit, consume: tt.consume}
+			server := httptest.NewServer(services)
+			defer server.Close()
+			app := MakeHTTPHandler(nil, server.URL, server.URL, "token", zap.NewNop())
+
+			order, _ := json.Marshal(NewOrderResource{
+				Customer: server.URL + "/customer",
+				Address:  server.URL + "/address",
+				Card:     server.URL + "/card",
+				Checkout: server.URL + "/checkout",
+			})
+			request := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(string(order)))
+			request.Header.Set("Content-Type", "application/json")
+			response, err := app.Test(request, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != http.StatusConflict {
+				t.Errorf("status = %d, want %d", response.StatusCode, http.StatusConflict)
+			}
+			if !reflect.DeepEqual(services.calls, tt.calls) {
+				t.Errorf("calls = %q, want %q", services.calls, tt.calls)
+			}
+		})
+	}
+}
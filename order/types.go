@@ -54,10 +54,20 @@ type HealthCheck struct {
 type Item struct {
 	ID        string
 	ItemID    string
+	SKU       string
 	Quantity  int
 	UnitPrice float64
 }
 
+type Summary struct {
+	Subtotal float64
+	Coupon   string
+	Discount float64
+	Shipping float64
+	Tax      float64
+	Total    float64
+}
+
 type Shipment struct {
 	ID   string
 	Name string
@@ -68,6 +78,27 @@ type NewOrderResource struct {
 	Address  string
 	Card     string
 	Items    string
+	// Checkout is the cart checkout snapshot to order, preferred over Items
+	// as its lines and prices cannot change while the order is placed.
+	Checkout string
+}
+
+type Checkout struct {
+	ID      string
+	Items   []Item
+	Summary Summary
+	Status  string
+}
+
+type Reservation struct {
+	ID    string            `json:"id"`
+	Items []ReservationItem `json:"items"`
+}
+
+type ReservationItem struct {
+	ID       string `json:"id"`
+	SKU      string `json:"sku,omitempty"`
+	Quantity int    `json:"quantity"`
 }
 
 type PaymentRequest struct {
@@ -78,6 +109,7 @@ type PaymentRequest struct {
 }
 
 type PaymentResponse struct {
+	ID         string
 	Authorised bool
 	Message    string
 }
@@ -24,6 +24,20 @@ func (mw *loggingMiddleware) Authorise(amount float32) (auth Authorisation, err
 	return mw.next.Authorise(amount)
 }
 
+func (mw *loggingMiddleware) Void(id string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method Void", zap.String("id", id), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.Void(id)
+}
+
+func (mw *loggingMiddleware) Capture(id string) (err error) {
+	defer func(begin time.Time) {
+		mw.logger.Info("method Capture", zap.String("id", id), zap.Error(err), zap.Duration("took", time.Since(begin)))
+	}(time.Now())
+	return mw.next.Capture(id)
+}
+
 func (mw *loggingMiddleware) Health() (health []Health) {
 	defer func(begin time.Time) {
 		mw.logger.Info("method Health", zap.Int("result", len(health)), zap.Duration("took", time.Since(begin)))
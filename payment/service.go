@@ -1,19 +1,31 @@
 package payment
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 )
 
+var ErrUnknownAuthorisation = errors.New("unknown authorisation")
+
 type Middleware func(Service) Service
 
 type Service interface {
 	Authorise(total float32) (Authorisation, error)
+	// Void cancels an authorised payment that is not going to be taken,
+	// such as that of an order that failed after it was authorised.
+	Void(id string) error
+	// Capture takes an authorised payment, such as that of an order once it
+	// is saved. Either it or Void settles every authorisation.
+	Capture(id string) error
 	Health() []Health
 }
 
 type Authorisation struct {
+	ID         string `json:"id,omitempty"`
 	Authorised bool   `json:"authorised"`
 	Message    string `json:"message"`
 }
@@ -26,10 +38,16 @@ type Health struct {
 
 type service struct {
 	declineOverAmount float32
+
+	mu         sync.Mutex
+	authorised map[string]float32
 }
 
 func NewAuthorisationService(declineOverAmount float32) Service {
-	return &service{declineOverAmount}
+	return &service{
+		declineOverAmount: declineOverAmount,
+		authorised:        map[string]float32{},
+	}
 }
 
 func (s *service) Authorise(amount float32) (Authorisation, error) {
@@ -38,19 +56,50 @@ func (s *service) Authorise(amount float32) (Authorisation, error) {
 	}
 
 	authorised := amount <= s.declineOverAmount
-	var message string
-	if authorised {
-		message = "Payment authorised"
-	} else {
-		message = fmt.Sprintf("Payment declined: amount exceeds %.2f", s.declineOverAmount)
+	if !authorised {
+		return Authorisation{
+			Authorised: false,
+			Message:    fmt.Sprintf("Payment declined: amount exceeds %.2f", s.declineOverAmount),
+		}, nil
 	}
 
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Authorisation{}, err
+	}
+	id := hex.EncodeToString(b)
+	s.mu.Lock()
+	s.authorised[id] = amount
+	s.mu.Unlock()
+
 	return Authorisation{
-		Authorised: authorised,
-		Message:    message,
+		ID:         id,
+		Authorised: true,
+		Message:    "Payment authorised",
 	}, nil
 }
 
+func (s *service) Void(id string) error {
+	return s.settle(id)
+}
+
+func (s *service) Capture(id string) error {
+	return s.settle(id)
+}
+
+// settle forgets the authorisation once it has been taken or voided, so
+// that it cannot be settled again.
+func (s *service) settle(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.authorised[id]; !ok {
+		return ErrUnknownAuthorisation
+	}
+	delete(s.authorised, id)
+	return nil
+}
+
 func (s *service) Health() []Health {
 	var health []Health
 	app := Health{"payment", "OK", time.Now().String()}
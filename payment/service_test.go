@@ -0,0 +1,37 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSettle(t *testing.T) {
+	tests := []struct {
+		name   string
+		settle func(s Service, id string) error
+	}{
+		{name: "capture", settle: Service.Capture},
+		{name: "void", settle: Service.Void},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewAuthorisationService(100)
+			authorisation, err := s.Authorise(10)
+			if err != nil {
+				t.Fatalf("authorise: %v", err)
+			}
+
+			if err := tt.settle(s, authorisation.ID); err != nil {
+				t.Fatalf("settle: %v", err)
+			}
+			if n := len(s.(*service).authorised); n != 0 {
+				t.Errorf("%d authorisations kept once settled", n)
+			}
+			for _, settle := range tests {
+				if err := settle.settle(s, authorisation.ID); !errors.Is(err, ErrUnknownAuthorisation) {
+					t.Errorf("%s once settled: %v, want %v", settle.name, err, ErrUnknownAuthorisation)
+				}
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package payment
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
 )
 
 func MakeHTTPHandler(service Service) *fiber.App {
 	app := fiber.New()
 	app.Post("/paymentauth", auth(service))
+	app.Delete("/paymentauth/:id", settle(service.Void))
+	app.Post("/paymentauth/:id/capture", settle(service.Capture))
 	app.Get("/health", health(service))
 	return app
 }
@@ -28,6 +32,18 @@ func auth(service Service) func(c *fiber.Ctx) error {
 	}
 }
 
+func settle(settle func(id string) error) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if err := settle(c.Params("id")); err != nil {
+			if errors.Is(err, ErrUnknownAuthorisation) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return err
+		}
+		return c.SendStatus(fiber.StatusOK)
+	}
+}
+
 func decodeAuthoriseRequest(c *fiber.Ctx) (float32, error) {
 	var amount float32
 	if err := c.BodyParser(&amount); err != nil {